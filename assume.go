@@ -1,11 +1,15 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"strings"
+	"text/template"
 	"time"
 )
 
@@ -21,257 +25,571 @@ type Credentials struct {
 	Expiration      time.Time `json:"Expiration"`
 }
 
+// credentialProcessOutput is the JSON document expected from a credential_process command
+type credentialProcessOutput struct {
+	Version         int    `json:"Version"`
+	AccessKeyId     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	SessionToken    string `json:"SessionToken"`
+	Expiration      string `json:"Expiration"`
+}
+
+// roleOptions holds the settings used to obtain temporary credentials
+type roleOptions struct {
+	SourceProfile string
+	// RoleArns lists the roles to assume in order, see parseRoleHop
+	RoleArns []string
+	MFAToken string
+	// The SSO settings sign in to IAM Identity Center instead of using a source profile
+	SSOStartURL  string
+	SSORegion    string
+	SSOAccountID string
+	SSORoleName  string
+	// SAMLAssertionFile (- for stdin) or SAMLAssertionEnv assume the first role with a SAML assertion
+	SAMLAssertionFile string
+	SAMLAssertionEnv  string
+	// WebIdentityTokenFile or WebIdentityTokenEnv assume the first role with an OIDC token
+	WebIdentityTokenFile string
+	WebIdentityTokenEnv  string
+	// ExternalID is used for every role without its own #external-id
+	ExternalID string
+	// MFAPrompt asks for the MFA code once the MFA device is known
+	MFAPrompt bool
+	// MFASerial and MFADevice choose the MFA device, see getMFADeviceARN
+	MFASerial string
+	MFADevice string
+	// SessionName and SourceIdentity may use the placeholders of expandSessionName
+	SessionName    string
+	SourceIdentity string
+	// Tags are session tags as key=value; TransitiveTags also carry over to chained roles
+	Tags           []string
+	TransitiveTags []string
+	// Policy, PolicyFile and PolicyArns are session policies for the last role
+	Policy        string
+	PolicyFile    string
+	PolicyArns    []string
+	Region        string
+	Duration      int
+	NoCache       bool
+	ForceRefresh  bool
+	RefreshWindow time.Duration
+}
+
 // generateTempProfile is the main function that generates temporary AWS credentials
-func generateTempProfile(sourceProfile, roleArn, mfaToken, newProfile, region string, duration int) error {
+func generateTempProfile(opts *roleOptions, newProfile string) error {
+	credentials, err := obtainCredentials(opts, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	// Set up the new profile with the credentials
+	fmt.Printf("Setting up profile %s...\n", newProfile)
+	err = withSharedFilesLock(func() error {
+		write, err := configureAWSProfile(newProfile, credentials, opts.SourceProfile, opts.Region, os.Stdout)
+		if err != nil {
+			return fmt.Errorf("error configuring AWS profile: %w", err)
+		}
+		if err := recordManagedProfile(newProfile, opts, credentials, write); err != nil {
+			fmt.Printf("Warning: Failed to record profile %s for refreshing and cleanup: %v\n", newProfile, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Calculate session duration
+	currentTime := time.Now()
+	durationHours := int(credentials.Expiration.Sub(currentTime).Hours())
+	durationMinutes := int(credentials.Expiration.Sub(currentTime).Minutes()) % 60
+
+	fmt.Printf("Temporary credentials for profile '%s' have been successfully configured\n", newProfile)
+	fmt.Printf("Credentials will expire at: %s (valid for approximately %dh %dm)\n\n",
+		credentials.Expiration.Local().Format("2006-01-02 15:04:05 MST"), durationHours, durationMinutes)
+	fmt.Printf("You can now use these credentials with: aws --profile %s <command>\n", newProfile)
+
+	return nil
+}
+
+// obtainCredentials assumes the role described by opts, reusing cached
+// credentials while they are still fresh, and reports progress to status
+func obtainCredentials(opts *roleOptions, status io.Writer) (*Credentials, error) {
 	var mfaSerial string
 	var err error
 
 	// Use default profile if no source profile is specified
 	profileArg := "--profile"
-	profileValue := sourceProfile
-
-	if sourceProfile == "" {
-		fmt.Println("No source profile specified, using default AWS profile")
+	profileValue := opts.SourceProfile
+
+	// Federated sessions start from a web identity token or SAML assertion instead of a profile
+	webIdentity := usesWebIdentity(opts)
+	saml := usesSAML(opts)
+	federated := webIdentity || saml
+	federation := "web identity token"
+	ssoConfig, err := resolveSSOConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case webIdentity:
+		fmt.Fprintf(status, "Using web identity token from %s\n", webIdentitySource(opts))
+		profileArg = ""
+		profileValue = ""
+	case saml:
+		fmt.Fprintf(status, "Using SAML assertion from %s\n", samlAssertionSource(opts))
+		federation = "SAML assertion"
+		profileArg = ""
+		profileValue = ""
+	case ssoConfig != nil:
+		fmt.Fprintf(status, "Using IAM Identity Center role %s in account %s\n", ssoConfig.RoleName, ssoConfig.AccountID)
+		profileArg = ""
+	case opts.SourceProfile == "":
+		fmt.Fprintf(status, "No source profile specified, using default AWS profile\n")
 		profileArg = "" // Don't use --profile flag when using default profile
 		profileValue = ""
+	default:
+		fmt.Fprintf(status, "Using source profile: %s\n", opts.SourceProfile)
+	}
+
+	// The SAML assertion lists the roles it can be used for; without --role-arn the user picks one
+	roleSpecs := opts.RoleArns
+	var samlAssertion string
+	var samlChoice samlRole
+	if saml {
+		samlAssertion, samlChoice, err = resolveSAMLRole(opts)
+		if err != nil {
+			return nil, err
+		}
+		if len(roleSpecs) == 0 {
+			roleSpecs = []string{samlChoice.RoleArn}
+		}
+	}
+
+	// IAM Identity Center credentials can be used as they are or to assume further roles
+	var hops []roleHop
+	chain := ""
+	if len(roleSpecs) > 0 || ssoConfig == nil {
+		hops, err = parseRoleHops(roleSpecs, opts.MFAToken != "" || opts.MFAPrompt)
+		if err != nil {
+			return nil, err
+		}
+		chain = strings.Join(roleArnsOf(hops), " -> ")
 	} else {
-		fmt.Printf("Using source profile: %s\n", sourceProfile)
+		chain = ssoConfig.RoleName + " in account " + ssoConfig.AccountID
+	}
+	if federated {
+		// The token or assertion replaces MFA, tags and source identity for the first role
+		if hops[0].MFA {
+			return nil, fmt.Errorf("role %s is assumed with a %s and cannot use MFA", hops[0].RoleArn, federation)
+		}
+		if len(hops) == 1 && (len(opts.Tags) > 0 || len(opts.TransitiveTags) > 0 || opts.SourceIdentity != "") {
+			return nil, fmt.Errorf("session tags and the source identity of %s sessions come from the identity provider", federation)
+		}
+	}
+	tags, err := parseSessionTags(opts.Tags)
+	if err != nil {
+		return nil, err
+	}
+	transitiveTags, err := parseSessionTags(opts.TransitiveTags)
+	if err != nil {
+		return nil, err
+	}
+	externalID := opts.ExternalID
+	if externalID == "" {
+		externalID, err = sourceProfileValue(profileValue, "external_id")
+		if err != nil {
+			return nil, err
+		}
+	}
+	policy, err := loadSessionPolicy(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := validatePolicyArns(opts.PolicyArns); err != nil {
+		return nil, err
+	}
+
+	// Role chaining limits every chained session to one hour, and IAM
+	// Identity Center credentials are already a role session
+	duration := opts.Duration
+	chained := len(hops) > 1 || (ssoConfig != nil && len(hops) > 0)
+	if chained && duration > maxChainedDuration {
+		fmt.Fprintf(status, "Warning: Chained role sessions are limited to 1 hour, reducing duration from %d to %d seconds\n", duration, maxChainedDuration)
+		duration = maxChainedDuration
+	}
+
+	// Reuse cached credentials unless they are close to expiring
+	key := newCacheKey(opts)
+	key.RoleArns = roleSpecs
+	if ssoConfig != nil {
+		key.SourceProfile = ssoCacheSource(ssoConfig)
+	}
+	key.Duration = duration
+	key.ExternalID = externalID
+	key.Policy = policy
+	if !opts.NoCache && !opts.ForceRefresh {
+		if cached := loadCachedCredentials(key, opts.RefreshWindow); cached != nil {
+			fmt.Fprintf(status, "Using cached credentials for role %s\n", chain)
+			return cached, nil
+		}
+	}
+
+	mfaHops := 0
+	for _, hop := range hops {
+		if hop.MFA {
+			mfaHops++
+		}
+	}
+
+	// A base session from the session command signs the first role whenever
+	// there is one, and stands in for its MFA code
+	var baseSession *Credentials
+	if len(hops) > 0 && !federated && ssoConfig == nil && !opts.NoCache {
+		baseSession = loadCachedCredentials(mfaSessionCacheKey(opts.SourceProfile), opts.RefreshWindow)
+		if baseSession != nil {
+			fmt.Fprintf(status, "Using MFA session, valid until %s\n", baseSession.Expiration.Local().Format("2006-01-02 15:04:05 MST"))
+			if hops[0].MFA {
+				hops[0].MFA = false
+				mfaHops--
+			}
+		}
 	}
 
-	// Only get MFA device if token is provided
-	if mfaToken != "" {
+	// Only get MFA device if a role needs MFA
+	if mfaHops > 0 {
 		// Get the MFA device ARN for the source profile
-		fmt.Printf("Getting MFA device ARN...\n")
-		mfaSerial, err = getMFADeviceARN(profileArg, profileValue)
+		fmt.Fprintf(status, "Getting MFA device ARN...\n")
+		mfaSerial, err = getMFADeviceARN(opts, profileArg, profileValue)
 		if err != nil {
-			return fmt.Errorf("error getting MFA device: %w", err)
+			return nil, fmt.Errorf("error getting MFA device: %w", err)
 		}
 
 		if mfaSerial == "None" || mfaSerial == "" {
-			return fmt.Errorf("no MFA device found, but MFA token was provided")
+			return nil, fmt.Errorf("no MFA device found, but MFA is required")
 		}
 
-		fmt.Printf("Found MFA device: %s\n", mfaSerial)
-	} else {
-		fmt.Println("No MFA token provided, assuming role without MFA")
+		fmt.Fprintf(status, "Found MFA device: %s\n", mfaSerial)
+	} else if len(hops) > 0 && baseSession == nil {
+		fmt.Fprintf(status, "No MFA token provided, assuming role without MFA\n")
 	}
 
 	// Display duration information
-	if duration == 3600 {
-		fmt.Println("Using default session duration of 1 hour (3600 seconds)")
+	if len(hops) == 0 {
+		fmt.Fprintf(status, "Using the session duration of the permission set\n")
+	} else if duration == 3600 {
+		fmt.Fprintf(status, "Using default session duration of 1 hour (3600 seconds)\n")
 	} else {
-		fmt.Printf("Using specified session duration of %d hours (%d seconds)\n", duration/3600, duration)
+		fmt.Fprintf(status, "Using specified session duration of %d hours (%d seconds)\n", duration/3600, duration)
 	}
 
-	// Assume the role with or without MFA
-	fmt.Printf("Assuming role %s...\n", roleArn)
-	credentials, err := assumeRole(profileArg, profileValue, roleArn, mfaSerial, mfaToken, duration)
+	// Federated sessions carry their own proof, so the first call is unsigned
+	var client stsAPI
+	var credentials *Credentials
+	switch {
+	case federated:
+		client, err = newAnonymousSTSClient(resolveRegion(profileValue))
+	case ssoConfig != nil:
+		// Sign-in instructions must be seen even when status is discarded
+		credentials, err = obtainSSOCredentials(ssoConfig, os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		client, err = newSessionSTSClient(credentials, resolveRegion(profileValue))
+	case baseSession != nil:
+		client, err = newSessionSTSClient(baseSession, resolveRegion(profileValue))
+	default:
+		client, err = newSTSClient(clientProfile(profileArg, profileValue))
+	}
 	if err != nil {
-		return fmt.Errorf("error assuming role: %w\n\nThis could be due to:\n"+
-			"1. The MFA token has expired or is incorrect\n"+
-			"2. Your device's time might be out of sync with AWS servers\n"+
-			"3. You might not have permission to assume this role\n"+
-			"4. The requested duration exceeds the role's maximum session duration\n"+
-			"5. MFA might be required for this role\n\n"+
-			"Try again with a shorter duration (e.g., 1 hour = 3600 seconds) or provide an MFA token if required", err)
+		return nil, err
 	}
 
-	// Set up the new profile with the credentials
-	fmt.Printf("Setting up profile %s...\n", newProfile)
-	if err := configureAWSProfile(newProfile, credentials, sourceProfile, region); err != nil {
-		return fmt.Errorf("error configuring AWS profile: %w", err)
+	// Name the session after the caller so CloudTrail shows who acted
+	var identity *callerIdentity
+	if sessionNameNeedsIdentity(opts.SessionName) || sessionNameNeedsIdentity(opts.SourceIdentity) {
+		if federated {
+			return nil, fmt.Errorf("{username} and {account} cannot be used with a %s", federation)
+		}
+		identity, err = client.GetCallerIdentity()
+		if err != nil {
+			return nil, fmt.Errorf("error getting caller identity for the session name: %w", err)
+		}
+	}
+	var sessionName, sourceIdentity string
+	if opts.SessionName != "" {
+		sessionName = expandSessionName(opts.SessionName, identity)
+		fmt.Fprintf(status, "Using role session name: %s\n", sessionName)
+	}
+	if opts.SourceIdentity != "" {
+		sourceIdentity = expandSessionName(opts.SourceIdentity, identity)
+		fmt.Fprintf(status, "Using source identity: %s\n", sourceIdentity)
 	}
 
-	// Calculate session duration
-	currentTime := time.Now()
-	durationHours := int(credentials.Expiration.Sub(currentTime).Hours())
-	durationMinutes := int(credentials.Expiration.Sub(currentTime).Minutes()) % 60
-
-	fmt.Printf("Temporary credentials for profile '%s' have been successfully configured\n", newProfile)
-	fmt.Printf("Credentials will expire at: %s (valid for approximately %dh %dm)\n\n",
-		credentials.Expiration.Local().Format("2006-01-02 15:04:05 MST"), durationHours, durationMinutes)
-	fmt.Printf("You can now use these credentials with: aws --profile %s <command>\n", newProfile)
+	// Assume each role in turn, signing every hop with the previous hop's credentials
+	mfaToken := opts.MFAToken
+	firstSignedHop := 0
+	if federated {
+		firstSignedHop = 1
+	}
+	for i, hop := range hops {
+		if i == 0 && federated {
+			fmt.Fprintf(status, "Assuming role %s with %s...\n", hop.RoleArn, federation)
+		} else if len(hops) > 1 {
+			fmt.Fprintf(status, "Assuming role %s (hop %d of %d)...\n", hop.RoleArn, i+1, len(hops))
+		} else {
+			fmt.Fprintf(status, "Assuming role %s...\n", hop.RoleArn)
+		}
 
-	return nil
-}
+		input := &assumeRoleInput{
+			RoleArn:         hop.RoleArn,
+			RoleSessionName: sessionName,
+			DurationSeconds: duration,
+			ExternalId:      hop.ExternalID,
+			Tags:            tags,
+		}
+		if input.ExternalId == "" {
+			input.ExternalId = externalID
+		}
+		// Session policies scope down the role the credentials are for
+		if i == len(hops)-1 {
+			input.Policy = policy
+			input.PolicyArns = opts.PolicyArns
+		}
+		// The source identity and transitive tags are inherited by the later hops
+		if i == firstSignedHop {
+			input.SourceIdentity = sourceIdentity
+			input.Tags = append(append([]sessionTag{}, tags...), transitiveTags...)
+			for _, tag := range transitiveTags {
+				input.TransitiveTagKeys = append(input.TransitiveTagKeys, tag.Key)
+			}
+		}
+		if hop.MFA {
+			// A code can only be used once, so later MFA hops prompt for a fresh one
+			code := mfaToken
+			mfaToken = ""
+			if code == "" {
+				code, err = readMFACode(mfaSerial)
+				if err != nil {
+					return nil, err
+				}
+			}
+			input.SerialNumber = mfaSerial
+			input.TokenCode = code
+		}
 
-// getMFADeviceARN gets the MFA device ARN for the given profile
-func getMFADeviceARN(profileArg, profileValue string) (string, error) {
-	var args []string
+		var result *assumeRoleResult
+		if i == 0 && webIdentity {
+			result, err = assumeRoleWithWebIdentity(client, opts, input)
+			if err != nil {
+				return nil, fmt.Errorf("error assuming role %s with web identity: %w\n\n"+
+					"Check that the role's trust policy allows the token's issuer, audience and subject", hop.RoleArn, err)
+			}
+		} else if i == 0 && saml {
+			result, err = assumeRoleWithSAML(client, samlAssertion, samlChoice, input)
+			if err != nil {
+				return nil, fmt.Errorf("error assuming role %s with SAML: %w\n\n"+
+					"SAML assertions expire after a few minutes, so this could mean you need to sign in to your identity provider again", hop.RoleArn, err)
+			}
+		} else {
+			result, err = assumeRole(client, input)
+		}
+		if err != nil && input.ExternalId == "" && isAccessDenied(err) {
+			return nil, fmt.Errorf("error assuming role %s: %w\n\n"+
+				"Roles set up for third parties usually require an external ID. "+
+				"If this is one of them, pass it with --external-id or append #external-id=ID to the role ARN", hop.RoleArn, err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error assuming role %s: %w\n\nThis could be due to:\n"+
+				"1. The MFA token has expired or is incorrect\n"+
+				"2. Your device's time might be out of sync with AWS servers\n"+
+				"3. You might not have permission to assume this role\n"+
+				"4. The requested duration exceeds the role's maximum session duration\n"+
+				"5. MFA might be required for this role\n\n"+
+				"Try again with a shorter duration (e.g., 1 hour = 3600 seconds) or provide an MFA token if required", hop.RoleArn, err)
+		}
+		credentials = &result.Credentials
+		if input.Policy != "" || len(input.PolicyArns) > 0 {
+			fmt.Fprintf(status, "Session policies use %d%% of the packed policy size limit\n", result.PackedPolicySize)
+			if result.PackedPolicySize >= 90 {
+				fmt.Fprintf(status, "Warning: Session policies are close to the packed policy size limit\n")
+			}
+		}
 
-	// Only add profile arguments if a profile is specified
-	if profileArg != "" && profileValue != "" {
-		args = append(args, profileArg, profileValue)
+		if i < len(hops)-1 {
+			client, err = newSessionSTSClient(credentials, resolveRegion(profileValue))
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	args = append(args, "iam", "list-mfa-devices", "--query", "MFADevices[0].SerialNumber", "--output", "text")
-
-	cmd := execCommand("aws", args...)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("failed to get MFA device: %w\nOutput: %s", err, string(output))
+	// A failed cache write only costs a later MFA prompt, so just warn
+	if !opts.NoCache {
+		if err := saveCachedCredentials(key, credentials); err != nil {
+			fmt.Fprintf(status, "Warning: Failed to cache credentials: %v\n", err)
+		}
 	}
-	return strings.TrimSpace(string(output)), nil
-}
-
-// assumeRole assumes the specified role with or without MFA and returns the credentials
-func assumeRole(profileArg, profileValue, roleArn, mfaSerial, mfaToken string, duration int) (*Credentials, error) {
-	sessionName := fmt.Sprintf("TempSession-%d", time.Now().Unix())
 
-	// Build command arguments
-	var args []string
+	return credentials, nil
+}
 
-	// Only add profile arguments if a profile is specified
-	if profileArg != "" && profileValue != "" {
-		args = append(args, profileArg, profileValue)
+// isAccessDenied reports whether err is an AccessDenied error from STS
+func isAccessDenied(err error) bool {
+	var apiErr *awsAPIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == "AccessDenied"
 	}
+	// The aws CLI backend only has the error message
+	return strings.Contains(err.Error(), "AccessDenied")
+}
 
-	args = append(args, "sts", "assume-role",
-		"--role-arn", roleArn,
-		"--role-session-name", sessionName,
-		"--duration-seconds", fmt.Sprintf("%d", duration),
-		"--query", "Credentials",
-		"--output", "json")
-
-	// Add MFA parameters only if MFA token is provided
-	if mfaToken != "" && mfaSerial != "" {
-		args = append(args, "--serial-number", mfaSerial, "--token-code", mfaToken)
+// assumeRole assumes the role described by input with the given client
+func assumeRole(client stsAPI, input *assumeRoleInput) (*assumeRoleResult, error) {
+	if input.RoleSessionName == "" {
+		input.RoleSessionName = defaultSessionName()
 	}
 
-	cmd := execCommand("aws", args...)
-
-	output, err := cmd.CombinedOutput()
+	result, err := client.AssumeRole(input)
 	if err != nil {
-		return nil, fmt.Errorf("failed to assume role: %w\nOutput: %s", err, string(output))
-	}
-
-	var credentials Credentials
-	if err := json.Unmarshal(output, &credentials); err != nil {
-		return nil, fmt.Errorf("failed to parse credentials: %w", err)
+		return nil, err
 	}
 
+	credentials := result.Credentials
 	if credentials.AccessKeyId == "" || credentials.SecretAccessKey == "" || credentials.SessionToken == "" {
 		return nil, fmt.Errorf("failed to get valid credentials from AWS response")
 	}
 
-	return &credentials, nil
+	return result, nil
 }
 
-// configureAWSProfile sets up a new AWS profile with the given credentials
-func configureAWSProfile(profile string, credentials *Credentials, sourceProfile, region string) error {
-	// Set the AWS access key
-	if err := runAWSConfigureCommand(profile, "aws_access_key_id", credentials.AccessKeyId); err != nil {
-		return fmt.Errorf("failed to set access key: %w", err)
-	}
+// defaultSessionName names sessions when --session-name is not given
+func defaultSessionName() string {
+	return fmt.Sprintf("TempSession-%d", time.Now().Unix())
+}
 
-	// Set the AWS secret key
-	if err := runAWSConfigureCommand(profile, "aws_secret_access_key", credentials.SecretAccessKey); err != nil {
-		return fmt.Errorf("failed to set secret key: %w", err)
+// clientProfile returns the profile to use, or "" for the default credential chain
+func clientProfile(profileArg, profileValue string) string {
+	// Only use the profile if one was specified
+	if profileArg != "" && profileValue != "" {
+		return profileValue
 	}
+	return ""
+}
 
-	// Set the AWS session token
-	if err := runAWSConfigureCommand(profile, "aws_session_token", credentials.SessionToken); err != nil {
-		return fmt.Errorf("failed to set session token: %w", err)
+// configureAWSProfile sets up a new AWS profile with the given credentials,
+// reporting the region it chose to status
+func configureAWSProfile(profile string, credentials *Credentials, sourceProfile, region string, status io.Writer) (profileWrite, error) {
+	credentialValues := [][2]string{
+		{"aws_access_key_id", credentials.AccessKeyId},
+		{"aws_secret_access_key", credentials.SecretAccessKey},
+		{"aws_session_token", credentials.SessionToken},
 	}
 
 	// Set the region for the new profile
+	var configValues [][2]string
 	if region != "" {
 		// Use the provided region
-		fmt.Printf("Setting region to %s...\n", region)
-		if err := runAWSConfigureCommand(profile, "region", region); err != nil {
-			return fmt.Errorf("failed to set region: %w", err)
-		}
+		fmt.Fprintf(status, "Setting region to %s...\n", region)
+		configValues = append(configValues, [2]string{"region", region})
 	} else {
 		// If no region was provided, use the region from the source profile
 		sourceRegion, err := getAWSConfigValue(sourceProfile, "region")
 		if err != nil {
-			fmt.Println("Warning: Error getting region from source profile")
+			fmt.Fprintln(status, "Warning: Error getting region from source profile")
 		} else if sourceRegion != "" {
-			fmt.Printf("Setting region to %s (from source profile)...\n", sourceRegion)
-			if err := runAWSConfigureCommand(profile, "region", sourceRegion); err != nil {
-				return fmt.Errorf("failed to set region: %w", err)
-			}
+			fmt.Fprintf(status, "Setting region to %s (from source profile)...\n", sourceRegion)
+			configValues = append(configValues, [2]string{"region", sourceRegion})
 		} else {
-			fmt.Println("Warning: No region specified and source profile has no region set.")
+			fmt.Fprintln(status, "Warning: No region specified and source profile has no region set.")
 		}
 	}
 
-	return nil
-}
-
-// runAWSConfigureCommand runs the aws configure command to set a specific value
-func runAWSConfigureCommand(profile, key, value string) error {
-	cmd := execCommand("aws", "configure", "set", key, value, "--profile", profile)
-	output, err := cmd.CombinedOutput()
+	// Write all keys in one atomic update per file so a failure never leaves a half-written profile
+	write, err := writeProfile(profile, credentialValues, configValues)
 	if err != nil {
-		return fmt.Errorf("%w\nOutput: %s", err, string(output))
+		return write, fmt.Errorf("failed to write profile: %w", err)
 	}
-	return nil
+
+	return write, nil
 }
 
 // getAWSConfigValue gets a configuration value from an AWS profile
 func getAWSConfigValueFunc(profile, key string) (string, error) {
-	cmd := execCommand("aws", "configure", "get", key, "--profile", profile)
-	output, err := cmd.CombinedOutput()
+	settings, _, err := loadProfile(effectiveProfile(profile))
 	if err != nil {
 		return "", fmt.Errorf("failed to get %s for profile %s: %w", key, profile, err)
 	}
-	return strings.TrimSpace(string(output)), nil
+	return settings[key], nil
 }
 
-// outputTempCredentials generates temporary AWS credentials and outputs them to stdout
-func outputTempCredentials(sourceProfile, roleArn, mfaToken, region string, duration int, outputFormat string) error {
-	var mfaSerial string
-	var err error
-
-	// Use default profile if no source profile is specified
-	profileArg := "--profile"
-	profileValue := sourceProfile
-
-	if sourceProfile == "" {
-		fmt.Fprintf(os.Stderr, "No source profile specified, using default AWS profile\n")
-		profileArg = "" // Don't use --profile flag when using default profile
-		profileValue = ""
-	} else {
-		fmt.Fprintf(os.Stderr, "Using source profile: %s\n", sourceProfile)
+// sourceProfileValue reads key from the source profile. Environment
+// credentials don't belong to the default profile, so its settings don't apply.
+func sourceProfileValue(profile, key string) (string, error) {
+	if profile == "" && os.Getenv("AWS_ACCESS_KEY_ID") != "" {
+		return "", nil
 	}
+	return getAWSConfigValue(profile, key)
+}
 
-	// Only get MFA device if token is provided
-	if mfaToken != "" {
-		// Get the MFA device ARN for the source profile
-		fmt.Fprintf(os.Stderr, "Getting MFA device ARN...\n")
-		mfaSerial, err = getMFADeviceARN(profileArg, profileValue)
-		if err != nil {
-			return fmt.Errorf("error getting MFA device: %w", err)
+// credentialRegion returns the region to use with the credentials: --region,
+// or the region of the source profile if there is one
+func credentialRegion(opts *roleOptions) string {
+	if opts.Region != "" {
+		return opts.Region
+	}
+	// Try to get region from source profile if not provided
+	if opts.SourceProfile != "" {
+		sourceRegion, err := getAWSConfigValue(opts.SourceProfile, "region")
+		if err == nil && sourceRegion != "" {
+			return sourceRegion
 		}
+	}
+	return ""
+}
 
-		if mfaSerial == "None" || mfaSerial == "" {
-			return fmt.Errorf("no MFA device found, but MFA token was provided")
-		}
+// outputOptions holds how generate writes the credentials
+type outputOptions struct {
+	Format string
+	// Template or TemplateFile is the text/template of the template format
+	Template     string
+	TemplateFile string
+	// OutFile replaces stdout, and OutFileTTL deletes it once the credentials expire
+	OutFile    string
+	OutFileTTL bool
+}
 
-		fmt.Fprintf(os.Stderr, "Found MFA device: %s\n", mfaSerial)
-	} else {
-		fmt.Fprintf(os.Stderr, "No MFA token provided, assuming role without MFA\n")
+// outputTempCredentials generates temporary AWS credentials and outputs them
+// to stdout or the output file
+func outputTempCredentials(opts *roleOptions, out *outputOptions) error {
+	outputFormat := out.Format
+
+	// credential_process consumers expect nothing but JSON, so keep stderr quiet on success
+	var status io.Writer = os.Stderr
+	if outputFormat == "credential-process" {
+		status = io.Discard
 	}
 
-	// Display duration information
-	if duration == 3600 {
-		fmt.Fprintf(os.Stderr, "Using default session duration of 1 hour (3600 seconds)\n")
-	} else {
-		fmt.Fprintf(os.Stderr, "Using specified session duration of %d hours (%d seconds)\n", duration/3600, duration)
+	if out.OutFileTTL && out.OutFile == "" {
+		return fmt.Errorf("--out-file-ttl requires --out-file")
+	}
+	if out.OutFile != "" && outputFormat == "github" {
+		return fmt.Errorf("-o github writes to $GITHUB_ENV and can't be used with --out-file")
 	}
 
-	// Assume the role with or without MFA
-	fmt.Fprintf(os.Stderr, "Assuming role %s...\n", roleArn)
-	credentials, err := assumeRole(profileArg, profileValue, roleArn, mfaSerial, mfaToken, duration)
+	// Check the template before assuming the role, which might ask for an MFA code
+	var tmpl *template.Template
+	if outputFormat == "template" {
+		var err error
+		if tmpl, err = parseOutputTemplate(out); err != nil {
+			return err
+		}
+	} else if out.Template != "" || out.TemplateFile != "" {
+		return fmt.Errorf("--template and --template-file require -o template")
+	}
+
+	credentials, err := obtainCredentials(opts, status)
 	if err != nil {
-		return fmt.Errorf("error assuming role: %w\n\nThis could be due to:\n"+
-			"1. The MFA token has expired or is incorrect\n"+
-			"2. Your device's time might be out of sync with AWS servers\n"+
-			"3. You might not have permission to assume this role\n"+
-			"4. The requested duration exceeds the role's maximum session duration\n"+
-			"5. MFA might be required for this role\n\n"+
-			"Try again with a shorter duration (e.g., 1 hour = 3600 seconds) or provide an MFA token if required", err)
+		return err
 	}
 
 	// Calculate session duration
@@ -279,11 +597,27 @@ func outputTempCredentials(sourceProfile, roleArn, mfaToken, region string, dura
 	durationHours := int(credentials.Expiration.Sub(currentTime).Hours())
 	durationMinutes := int(credentials.Expiration.Sub(currentTime).Minutes()) % 60
 
-	fmt.Fprintf(os.Stderr, "Temporary credentials have been successfully generated\n")
-	fmt.Fprintf(os.Stderr, "Credentials will expire at: %s (valid for approximately %dh %dm)\n\n",
+	fmt.Fprintf(status, "Temporary credentials have been successfully generated\n")
+	fmt.Fprintf(status, "Credentials will expire at: %s (valid for approximately %dh %dm)\n\n",
 		credentials.Expiration.Local().Format("2006-01-02 15:04:05 MST"), durationHours, durationMinutes)
 
-	// Output credentials in the requested format
+	// Output credentials in the requested format, to the output file if there is one
+	if out.OutFile == "" {
+		return writeCredentialsFormat(os.Stdout, outputFormat, tmpl, opts, credentials)
+	}
+	var buf bytes.Buffer
+	if err := writeCredentialsFormat(&buf, outputFormat, tmpl, opts, credentials); err != nil {
+		return err
+	}
+	if err := writeOutFile(out.OutFile, buf.Bytes(), credentials.Expiration, out.OutFileTTL); err != nil {
+		return err
+	}
+	fmt.Fprintf(status, "Credentials written to %s\n", out.OutFile)
+	return nil
+}
+
+// writeCredentialsFormat writes credentials to w in outputFormat
+func writeCredentialsFormat(w io.Writer, outputFormat string, tmpl *template.Template, opts *roleOptions, credentials *Credentials) error {
 	switch outputFormat {
 	case "json":
 		// Output credentials as JSON
@@ -291,30 +625,42 @@ func outputTempCredentials(sourceProfile, roleArn, mfaToken, region string, dura
 		if err != nil {
 			return fmt.Errorf("error marshaling credentials to JSON: %w", err)
 		}
-		fmt.Println(string(jsonOutput))
-	case "shell", "":
-		// Output credentials as shell environment variables
-		if region == "" {
-			// Try to get region from source profile if not provided
-			if sourceProfile != "" {
-				sourceRegion, err := getAWSConfigValue(sourceProfile, "region")
-				if err == nil && sourceRegion != "" {
-					region = sourceRegion
-				}
-			}
+		fmt.Fprintln(w, string(jsonOutput))
+	case "credential-process":
+		// Output credentials in the schema expected by the credential_process setting
+		jsonOutput, err := json.Marshal(credentialProcessOutput{
+			Version:         1,
+			AccessKeyId:     credentials.AccessKeyId,
+			SecretAccessKey: credentials.SecretAccessKey,
+			SessionToken:    credentials.SessionToken,
+			Expiration:      credentials.Expiration.UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			return fmt.Errorf("error marshaling credentials to JSON: %w", err)
 		}
-
-		// Print shell commands to set environment variables
-		fmt.Printf("export AWS_ACCESS_KEY_ID=%s\n", credentials.AccessKeyId)
-		fmt.Printf("export AWS_SECRET_ACCESS_KEY=%s\n", credentials.SecretAccessKey)
-		fmt.Printf("export AWS_SESSION_TOKEN=%s\n", credentials.SessionToken)
-		if region != "" {
-			fmt.Printf("export AWS_REGION=%s\n", region)
-			fmt.Printf("export AWS_DEFAULT_REGION=%s\n", region)
+		fmt.Fprintln(w, string(jsonOutput))
+	case "github":
+		return writeGitHubEnv(w, credentials, credentialRegion(opts))
+	case "template":
+		return writeTemplate(w, tmpl, opts, credentials, credentialRegion(opts))
+	case "gitlab", "dotenv":
+		// GitLab dotenv reports are plain dotenv files
+		if f, ok := w.(*os.File); ok {
+			if err := checkNotJobLog(f); err != nil {
+				return err
+			}
 		}
-		fmt.Printf("export AWS_CREDENTIAL_EXPIRATION=%s\n", credentials.Expiration.Format(time.RFC3339))
+		return writeDotenv(w, credentials, credentialRegion(opts))
 	default:
-		return fmt.Errorf("unsupported output format: %s", outputFormat)
+		// Output credentials as environment variables for one of the shell dialects
+		if outputFormat == "" {
+			outputFormat = "shell"
+		}
+		dialect, ok := resolveShellDialect(outputFormat)
+		if !ok {
+			return fmt.Errorf("unsupported output format: %s", outputFormat)
+		}
+		return writeShellCredentials(w, dialect, credentials, credentialRegion(opts))
 	}
 
 	return nil
@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -51,10 +54,16 @@ func TestHelperProcess(t *testing.T) {
 		// Check for assume-role command (more flexible matching)
 		if contains(args, "assume-role") {
 			fmt.Fprintf(os.Stdout, `{
-				"AccessKeyId": "ASIAMOCK123456789012",
-				"SecretAccessKey": "mockSecretKey123456789012345678901234",
-				"SessionToken": "mockSessionToken123456789012345678901234567890123456789012345678901234567890",
-				"Expiration": "2023-12-31T23:59:59Z"
+				"Credentials": {
+					"AccessKeyId": "ASIAMOCK123456789012",
+					"SecretAccessKey": "mockSecretKey123456789012345678901234",
+					"SessionToken": "mockSessionToken123456789012345678901234567890123456789012345678901234567890",
+					"Expiration": "2023-12-31T23:59:59Z"
+				},
+				"AssumedRoleUser": {
+					"AssumedRoleId": "AROAMOCK123456789012:TempSession",
+					"Arn": "arn:aws:sts::123456789012:assumed-role/TestRole/TempSession"
+				}
 			}`)
 			os.Exit(0)
 		}
@@ -65,6 +74,20 @@ func TestHelperProcess(t *testing.T) {
 		}
 	}
 
+	// Mock command for exec that shows its AWS environment
+	if args[0] == "show-aws-env" {
+		for _, name := range []string{"AWS_PROFILE", "AWS_ACCESS_KEY_ID", "AWS_SESSION_TOKEN", "AWS_REGION", "AWS_CREDENTIAL_EXPIRATION"} {
+			fmt.Fprintf(os.Stdout, "%s=%s\n", name, os.Getenv(name))
+		}
+		os.Exit(3)
+	}
+
+	// Mock AWSOMECREDS_ASKPASS program
+	if args[0] == "mfa-askpass" {
+		fmt.Fprintf(os.Stdout, "123456\n")
+		os.Exit(0)
+	}
+
 	fmt.Fprintf(os.Stderr, "Unrecognized command: %v\n", args)
 	os.Exit(1)
 }
@@ -86,8 +109,13 @@ func TestGetMFADeviceARN(t *testing.T) {
 	execCommand = mockExecCommand
 	defer func() { execCommand = origExecCommand }()
 
+	// Use the aws CLI backend so the mocked exec.Command is called
+	origBackend := stsBackend
+	stsBackend = backendCLI
+	defer func() { stsBackend = origBackend }()
+
 	// Test with profile
-	mfaSerial, err := getMFADeviceARN("--profile", "test-profile")
+	mfaSerial, err := getMFADeviceARN(&roleOptions{}, "--profile", "test-profile")
 	if err != nil {
 		t.Errorf("getMFADeviceARN with profile failed: %v", err)
 	}
@@ -96,7 +124,7 @@ func TestGetMFADeviceARN(t *testing.T) {
 	}
 
 	// Test without profile
-	mfaSerial, err = getMFADeviceARN("", "")
+	mfaSerial, err = getMFADeviceARN(&roleOptions{}, "", "")
 	if err != nil {
 		t.Errorf("getMFADeviceARN without profile failed: %v", err)
 	}
@@ -112,33 +140,49 @@ func TestAssumeRole(t *testing.T) {
 	execCommand = mockExecCommand
 	defer func() { execCommand = origExecCommand }()
 
+	// Use the aws CLI backend so the mocked exec.Command is called
+	origBackend := stsBackend
+	stsBackend = backendCLI
+	defer func() { stsBackend = origBackend }()
+
+	client, err := newSTSClient("test-profile")
+	if err != nil {
+		t.Fatalf("newSTSClient failed: %v", err)
+	}
+
 	// Test with MFA
-	creds, err := assumeRole("--profile", "test-profile", "arn:aws:iam::123456789012:role/TestRole",
-		"arn:aws:iam::123456789012:mfa/user", "123456", 3600)
+	result, err := assumeRole(client, &assumeRoleInput{
+		RoleArn:         "arn:aws:iam::123456789012:role/TestRole",
+		DurationSeconds: 3600,
+		SerialNumber:    "arn:aws:iam::123456789012:mfa/user",
+		TokenCode:       "123456",
+	})
 	if err != nil {
-		t.Errorf("assumeRole with MFA failed: %v", err)
+		t.Fatalf("assumeRole with MFA failed: %v", err)
 	}
-	if creds.AccessKeyId != "ASIAMOCK123456789012" {
-		t.Errorf("Expected AccessKeyId 'ASIAMOCK123456789012', got '%s'", creds.AccessKeyId)
+	if result.Credentials.AccessKeyId != "ASIAMOCK123456789012" {
+		t.Errorf("Expected AccessKeyId 'ASIAMOCK123456789012', got '%s'", result.Credentials.AccessKeyId)
 	}
 
 	// Test without MFA
-	creds, err = assumeRole("--profile", "test-profile", "arn:aws:iam::123456789012:role/TestRole",
-		"", "", 3600)
+	result, err = assumeRole(client, &assumeRoleInput{
+		RoleArn:         "arn:aws:iam::123456789012:role/TestRole",
+		DurationSeconds: 3600,
+	})
 	if err != nil {
-		t.Errorf("assumeRole without MFA failed: %v", err)
+		t.Fatalf("assumeRole without MFA failed: %v", err)
 	}
-	if creds.AccessKeyId != "ASIAMOCK123456789012" {
-		t.Errorf("Expected AccessKeyId 'ASIAMOCK123456789012', got '%s'", creds.AccessKeyId)
+	if result.Credentials.AccessKeyId != "ASIAMOCK123456789012" {
+		t.Errorf("Expected AccessKeyId 'ASIAMOCK123456789012', got '%s'", result.Credentials.AccessKeyId)
 	}
 }
 
 // Test configureAWSProfile function
 func TestConfigureAWSProfile(t *testing.T) {
-	// Save original exec.Command and restore it after the test
-	origExecCommand := execCommand
-	execCommand = mockExecCommand
-	defer func() { execCommand = origExecCommand }()
+	credentialsFile := writeTestFile(t, "credentials", "# keep me\n[source-profile]\naws_access_key_id = AKIDSOURCE\n")
+	configFile := writeTestFile(t, "config", "[profile source-profile]\nregion = us-east-1\n")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentialsFile)
+	t.Setenv("AWS_CONFIG_FILE", configFile)
 
 	// Create test credentials
 	testCreds := &Credentials{
@@ -154,26 +198,13 @@ func TestConfigureAWSProfile(t *testing.T) {
 	os.Stdout = w
 
 	// Test with region
-	err := configureAWSProfile("test-profile", testCreds, "source-profile", "us-west-2")
+	_, err := configureAWSProfile("test-profile", testCreds, "source-profile", "us-west-2", io.Discard)
 	if err != nil {
 		t.Errorf("configureAWSProfile with region failed: %v", err)
 	}
 
-	// Test without region - mock a successful source profile region retrieval
-	// Add a mock response for getAWSConfigValue
-	getConfigValueMock := func(profile, key string) (string, error) {
-		if profile == "source-profile" && key == "region" {
-			return "us-east-1", nil
-		}
-		return "", nil
-	}
-
-	// Save the original function and restore it after the test
-	origGetAWSConfigValue := getAWSConfigValue
-	getAWSConfigValue = getConfigValueMock
-	defer func() { getAWSConfigValue = origGetAWSConfigValue }()
-
-	err = configureAWSProfile("test-profile", testCreds, "source-profile", "")
+	// Test without region - the region comes from the source profile
+	_, err = configureAWSProfile("other-profile", testCreds, "source-profile", "", io.Discard)
 	if err != nil {
 		t.Errorf("configureAWSProfile without region failed: %v", err)
 	}
@@ -182,6 +213,25 @@ func TestConfigureAWSProfile(t *testing.T) {
 	w.Close()
 	os.Stdout = oldStdout
 	io.Copy(io.Discard, r) // Discard captured output
+
+	credentialsData, _ := os.ReadFile(credentialsFile)
+	configData, _ := os.ReadFile(configFile)
+	expectedCredentials := "# keep me\n[source-profile]\naws_access_key_id = AKIDSOURCE\n\n" +
+		"[test-profile]\n" +
+		"aws_access_key_id = ASIAMOCK123456789012\n" +
+		"aws_secret_access_key = mockSecretKey123456789012345678901234\n" +
+		"aws_session_token = mockSessionToken123456789012345678901234567890123456789012345678901234567890\n"
+	if !strings.HasPrefix(string(credentialsData), expectedCredentials) {
+		t.Errorf("Unexpected credentials file:\n%s", credentialsData)
+	}
+	if !strings.Contains(string(credentialsData), "[other-profile]\n") {
+		t.Errorf("Expected credentials file to contain [other-profile]:\n%s", credentialsData)
+	}
+	for _, expected := range []string{"[profile test-profile]\nregion = us-west-2\n", "[profile other-profile]\nregion = us-east-1\n"} {
+		if !strings.Contains(string(configData), expected) {
+			t.Errorf("Expected config file to contain %q:\n%s", expected, configData)
+		}
+	}
 }
 
 // Test outputTempCredentials function
@@ -191,6 +241,11 @@ func TestOutputTempCredentials(t *testing.T) {
 	execCommand = mockExecCommand
 	defer func() { execCommand = origExecCommand }()
 
+	// Use the aws CLI backend so the mocked exec.Command is called
+	origBackend := stsBackend
+	stsBackend = backendCLI
+	defer func() { stsBackend = origBackend }()
+
 	// Add a mock response for getAWSConfigValue
 	getConfigValueMock := func(profile, key string) (string, error) {
 		if profile == "source-profile" && key == "region" {
@@ -264,6 +319,22 @@ func TestOutputTempCredentials(t *testing.T) {
 				`"Expiration":`,
 			},
 		},
+		{
+			name:          "credential_process output",
+			sourceProfile: "source-profile",
+			roleArn:       "arn:aws:iam::123456789012:role/TestRole",
+			mfaToken:      "",
+			region:        "",
+			duration:      3600,
+			outputFormat:  "credential-process",
+			expectedOutput: []string{
+				`"Version":1`,
+				`"AccessKeyId":"ASIAMOCK123456789012"`,
+				`"SecretAccessKey":"mockSecretKey123456789012345678901234"`,
+				`"SessionToken":"mockSessionToken123456789012345678901234567890123456789012345678901234567890"`,
+				`"Expiration":"2023-12-31T23:59:59Z"`,
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -279,7 +350,14 @@ func TestOutputTempCredentials(t *testing.T) {
 			os.Stdout = stdoutW
 
 			// Call the function
-			err := outputTempCredentials(tc.sourceProfile, tc.roleArn, tc.mfaToken, tc.region, tc.duration, tc.outputFormat)
+			opts := &roleOptions{
+				SourceProfile: tc.sourceProfile,
+				RoleArns:      []string{tc.roleArn},
+				MFAToken:      tc.mfaToken,
+				Region:        tc.region,
+				Duration:      tc.duration,
+			}
+			err := outputTempCredentials(opts, &outputOptions{Format: tc.outputFormat})
 
 			// Close the write end of the pipes to complete the capture
 			stdoutW.Close()
@@ -293,14 +371,20 @@ func TestOutputTempCredentials(t *testing.T) {
 			var stdoutBuf bytes.Buffer
 			io.Copy(&stdoutBuf, stdoutR)
 
-			// Discard stderr output
-			io.Copy(io.Discard, stderrR)
+			// Read stderr output
+			var stderrBuf bytes.Buffer
+			io.Copy(&stderrBuf, stderrR)
 
 			// Check for errors
 			if err != nil {
 				t.Errorf("outputTempCredentials failed: %v", err)
 			}
 
+			// credential_process output must not print anything to stderr on success
+			if tc.outputFormat == "credential-process" && stderrBuf.Len() > 0 {
+				t.Errorf("Expected no stderr output, got: %s", stderrBuf.String())
+			}
+
 			// Check that the output contains expected strings
 			output := stdoutBuf.String()
 			for _, expected := range tc.expectedOutput {
@@ -311,3 +395,104 @@ func TestOutputTempCredentials(t *testing.T) {
 		})
 	}
 }
+
+// Test where the external ID comes from and the hint when it is missing
+func TestObtainCredentialsExternalID(t *testing.T) {
+	var externalIDs []string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		externalID := r.PostForm.Get("ExternalId")
+		externalIDs = append(externalIDs, externalID)
+		if externalID == "" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<ErrorResponse><Error><Code>AccessDenied</Code><Message>not authorized to perform sts:AssumeRole</Message></Error></ErrorResponse>`)
+			return
+		}
+		fmt.Fprint(w, mockAssumeRoleResponse)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+	t.Setenv("AWS_CONFIG_FILE", writeTestFile(t, "config", "[profile vendor]\nexternal_id = from-profile\n"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", writeTestFile(t, "credentials", "[vendor]\naws_access_key_id = AKIDTEST\naws_secret_access_key = secret\n[plain]\naws_access_key_id = AKIDTEST\naws_secret_access_key = secret\n"))
+
+	testCases := []struct {
+		name       string
+		opts       roleOptions
+		expectedID string
+	}{
+		{name: "missing", opts: roleOptions{SourceProfile: "plain"}, expectedID: ""},
+		{name: "flag", opts: roleOptions{SourceProfile: "plain", ExternalID: "from-flag"}, expectedID: "from-flag"},
+		{name: "profile", opts: roleOptions{SourceProfile: "vendor"}, expectedID: "from-profile"},
+		{name: "flag over profile", opts: roleOptions{SourceProfile: "vendor", ExternalID: "from-flag"}, expectedID: "from-flag"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			externalIDs = nil
+			tc.opts.RoleArns = []string{"arn:aws:iam::123456789012:role/VendorRole"}
+			tc.opts.Duration = 3600
+			tc.opts.NoCache = true
+
+			_, err := obtainCredentials(&tc.opts, io.Discard)
+			if tc.expectedID == "" {
+				if err == nil || !strings.Contains(err.Error(), "--external-id") {
+					t.Errorf("Expected an external ID hint, got %v", err)
+				}
+			} else if err != nil {
+				t.Fatalf("obtainCredentials failed: %v", err)
+			}
+			if len(externalIDs) != 1 || externalIDs[0] != tc.expectedID {
+				t.Errorf("Expected external ID %q, got %v", tc.expectedID, externalIDs)
+			}
+		})
+	}
+}
+
+// Test that --out-file replaces stdout and which flag combinations are refused
+func TestOutputTempCredentialsOutFile(t *testing.T) {
+	newMockSTSServer(t, map[string]string{"AssumeRole": mockAssumeRoleResponse})
+	dir := t.TempDir()
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", writeTestFile(t, "credentials", "[plain]\naws_access_key_id = AKIDTEST\naws_secret_access_key = secret\n"))
+	t.Setenv("AWS_CONFIG_FILE", writeTestFile(t, "config", ""))
+
+	opts := &roleOptions{SourceProfile: "plain", RoleArns: []string{"arn:aws:iam::123456789012:role/TestRole"}, Duration: 3600, NoCache: true}
+	path := filepath.Join(dir, "creds.env")
+
+	oldStdout, oldStderr := os.Stdout, os.Stderr
+	stdoutR, stdoutW, _ := os.Pipe()
+	os.Stdout, os.Stderr = stdoutW, stdoutW
+	err := outputTempCredentials(opts, &outputOptions{Format: "dotenv", OutFile: path, OutFileTTL: true})
+	stdoutW.Close()
+	os.Stdout, os.Stderr = oldStdout, oldStderr
+	var output bytes.Buffer
+	io.Copy(&output, stdoutR)
+	if err != nil {
+		t.Fatalf("outputTempCredentials failed: %v", err)
+	}
+
+	if strings.Contains(output.String(), "ASIAMOCK123456789012") {
+		t.Errorf("Expected no credentials on stdout or stderr, got: %s", output.String())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	if !strings.Contains(string(data), "AWS_ACCESS_KEY_ID=ASIAMOCK123456789012\n") {
+		t.Errorf("Unexpected output file content: %s", data)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+	}
+	if files, _ := loadManagedOutFiles(); len(files) != 1 || files[0].Path != path {
+		t.Errorf("Expected the output file to be recorded, got %+v", files)
+	}
+
+	for _, out := range []outputOptions{
+		{Format: "dotenv", OutFileTTL: true},
+		{Format: "github", OutFile: path},
+	} {
+		if err := outputTempCredentials(opts, &out); err == nil {
+			t.Errorf("Expected an error for %+v", out)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// cliSTSClient calls STS and IAM by running the aws CLI
+type cliSTSClient struct {
+	profile string
+	// credentials and region are passed through the environment when set
+	credentials *Credentials
+	region      string
+	// anonymous sends unsigned requests, so no credentials need to be configured
+	anonymous bool
+}
+
+// AssumeRole runs aws sts assume-role
+func (c *cliSTSClient) AssumeRole(input *assumeRoleInput) (*assumeRoleResult, error) {
+	args := []string{"sts", "assume-role",
+		"--role-arn", input.RoleArn,
+		"--role-session-name", input.RoleSessionName,
+		"--duration-seconds", fmt.Sprintf("%d", input.DurationSeconds),
+		"--output", "json"}
+
+	if input.ExternalId != "" {
+		args = append(args, "--external-id", input.ExternalId)
+	}
+
+	// Add MFA parameters only if MFA token is provided
+	if input.TokenCode != "" && input.SerialNumber != "" {
+		args = append(args, "--serial-number", input.SerialNumber, "--token-code", input.TokenCode)
+	}
+
+	if input.SourceIdentity != "" {
+		args = append(args, "--source-identity", input.SourceIdentity)
+	}
+	if len(input.Tags) > 0 {
+		// JSON avoids the shorthand syntax, which can't express commas in values
+		tags, err := json.Marshal(input.Tags)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "--tags", string(tags))
+	}
+	if len(input.TransitiveTagKeys) > 0 {
+		args = append(args, "--transitive-tag-keys")
+		args = append(args, input.TransitiveTagKeys...)
+	}
+	if input.Policy != "" {
+		args = append(args, "--policy", input.Policy)
+	}
+	if len(input.PolicyArns) > 0 {
+		args = append(args, "--policy-arns")
+		for _, arn := range input.PolicyArns {
+			args = append(args, "arn="+arn)
+		}
+	}
+
+	var result assumeRoleResult
+	if err := c.run(args, &result); err != nil {
+		return nil, fmt.Errorf("failed to assume role: %w", err)
+	}
+	return &result, nil
+}
+
+// AssumeRoleWithWebIdentity runs aws sts assume-role-with-web-identity
+func (c *cliSTSClient) AssumeRoleWithWebIdentity(input *webIdentityInput) (*assumeRoleResult, error) {
+	args := []string{"sts", "assume-role-with-web-identity",
+		"--role-arn", input.RoleArn,
+		"--role-session-name", input.RoleSessionName,
+		"--web-identity-token", input.WebIdentityToken,
+		"--duration-seconds", fmt.Sprintf("%d", input.DurationSeconds),
+		"--output", "json"}
+
+	if input.Policy != "" {
+		args = append(args, "--policy", input.Policy)
+	}
+	if len(input.PolicyArns) > 0 {
+		args = append(args, "--policy-arns")
+		for _, arn := range input.PolicyArns {
+			args = append(args, "arn="+arn)
+		}
+	}
+
+	var result assumeRoleResult
+	if err := c.run(args, &result); err != nil {
+		return nil, fmt.Errorf("failed to assume role with web identity: %w", err)
+	}
+	return &result, nil
+}
+
+// AssumeRoleWithSAML runs aws sts assume-role-with-saml
+func (c *cliSTSClient) AssumeRoleWithSAML(input *samlInput) (*assumeRoleResult, error) {
+	args := []string{"sts", "assume-role-with-saml",
+		"--role-arn", input.RoleArn,
+		"--principal-arn", input.PrincipalArn,
+		"--saml-assertion", input.SAMLAssertion,
+		"--duration-seconds", fmt.Sprintf("%d", input.DurationSeconds),
+		"--output", "json"}
+
+	if input.Policy != "" {
+		args = append(args, "--policy", input.Policy)
+	}
+	if len(input.PolicyArns) > 0 {
+		args = append(args, "--policy-arns")
+		for _, arn := range input.PolicyArns {
+			args = append(args, "arn="+arn)
+		}
+	}
+
+	var result assumeRoleResult
+	if err := c.run(args, &result); err != nil {
+		return nil, fmt.Errorf("failed to assume role with SAML: %w", err)
+	}
+	return &result, nil
+}
+
+// GetCallerIdentity runs aws sts get-caller-identity
+func (c *cliSTSClient) GetCallerIdentity() (*callerIdentity, error) {
+	var identity callerIdentity
+	if err := c.run([]string{"sts", "get-caller-identity", "--output", "json"}, &identity); err != nil {
+		return nil, fmt.Errorf("failed to get caller identity: %w", err)
+	}
+	return &identity, nil
+}
+
+// GetSessionToken runs aws sts get-session-token
+func (c *cliSTSClient) GetSessionToken(input *getSessionTokenInput) (*Credentials, error) {
+	args := []string{"sts", "get-session-token", "--output", "json"}
+	if input.DurationSeconds > 0 {
+		args = append(args, "--duration-seconds", fmt.Sprintf("%d", input.DurationSeconds))
+	}
+	if input.TokenCode != "" && input.SerialNumber != "" {
+		args = append(args, "--serial-number", input.SerialNumber, "--token-code", input.TokenCode)
+	}
+
+	var response struct {
+		Credentials Credentials `json:"Credentials"`
+	}
+	if err := c.run(args, &response); err != nil {
+		return nil, fmt.Errorf("failed to get session token: %w", err)
+	}
+	return &response.Credentials, nil
+}
+
+// ListMFADevices runs aws iam list-mfa-devices and returns the serial numbers
+func (c *cliSTSClient) ListMFADevices() ([]string, error) {
+	output, err := c.output([]string{"iam", "list-mfa-devices", "--query", "MFADevices[].SerialNumber", "--output", "text"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get MFA device: %w", err)
+	}
+
+	var serials []string
+	for _, serial := range strings.Fields(output) {
+		if serial != "None" {
+			serials = append(serials, serial)
+		}
+	}
+	return serials, nil
+}
+
+// run executes the aws CLI and decodes its JSON output into out
+func (c *cliSTSClient) run(args []string, out interface{}) error {
+	output, err := c.output(args)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(output), out); err != nil {
+		return fmt.Errorf("failed to parse aws CLI output: %w", err)
+	}
+	return nil
+}
+
+// output executes the aws CLI with the client's profile and returns its output
+func (c *cliSTSClient) output(args []string) (string, error) {
+	// Only add profile arguments if a profile is specified
+	if c.profile != "" {
+		args = append([]string{"--profile", c.profile}, args...)
+	}
+	if c.anonymous {
+		args = append([]string{"--no-sign-request"}, args...)
+		if c.region != "" {
+			args = append([]string{"--region", c.region}, args...)
+		}
+	}
+
+	cmd := execCommand("aws", args...)
+	if c.credentials != nil {
+		cmd.Env = credentialEnv(cmd.Environ(), c.credentials, c.region)
+	}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%w\nOutput: %s", err, string(output))
+	}
+	return string(output), nil
+}
+
+// credentialEnv returns env with any AWS profile or credential variables
+// replaced by credentials and region
+func credentialEnv(env []string, credentials *Credentials, region string) []string {
+	result := append(withoutCredentialEnv(env, region),
+		"AWS_ACCESS_KEY_ID="+credentials.AccessKeyId,
+		"AWS_SECRET_ACCESS_KEY="+credentials.SecretAccessKey,
+		"AWS_SESSION_TOKEN="+credentials.SessionToken)
+	if region != "" {
+		result = append(result, "AWS_REGION="+region, "AWS_DEFAULT_REGION="+region)
+	}
+	return result
+}
+
+// withoutCredentialEnv returns env without the profile and credential
+// variables, and without the region variables if region replaces them
+func withoutCredentialEnv(env []string, region string) []string {
+	result := make([]string, 0, len(env)+5)
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		switch name {
+		case "AWS_PROFILE", "AWS_DEFAULT_PROFILE", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN":
+			continue
+		case "AWS_REGION", "AWS_DEFAULT_REGION":
+			if region != "" {
+				continue
+			}
+		}
+		result = append(result, kv)
+	}
+	return result
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+// defaultRefreshWindow is how long before expiry cached credentials stop being reused
+const defaultRefreshWindow = 15 * time.Minute
+
+// cacheKey identifies the cached credentials for one role assumption
+type cacheKey struct {
+	SourceProfile string   `json:"SourceProfile"`
+	RoleArns      []string `json:"RoleArns"`
+	Region        string   `json:"Region"`
+	// Duration is the requested session duration after the chaining limit
+	Duration int `json:"Duration,omitempty"`
+	// MFASession marks the base session of the session command
+	MFASession bool `json:"MFASession,omitempty"`
+	// The session settings are only part of the key when they are used
+	ExternalID     string   `json:"ExternalID,omitempty"`
+	SessionName    string   `json:"SessionName,omitempty"`
+	SourceIdentity string   `json:"SourceIdentity,omitempty"`
+	Tags           []string `json:"Tags,omitempty"`
+	TransitiveTags []string `json:"TransitiveTags,omitempty"`
+	// Policy is the session policy document itself, so editing a policy file takes effect
+	Policy     string   `json:"Policy,omitempty"`
+	PolicyArns []string `json:"PolicyArns,omitempty"`
+}
+
+// cacheEntry is the document stored for each cached set of credentials
+type cacheEntry struct {
+	Key         cacheKey    `json:"Key"`
+	Credentials Credentials `json:"Credentials"`
+	CachedAt    time.Time   `json:"CachedAt"`
+}
+
+// newCacheKey builds the cache key for opts
+func newCacheKey(opts *roleOptions) cacheKey {
+	source := cacheSourceName(opts.SourceProfile)
+	if usesWebIdentity(opts) {
+		source = "web-identity:" + webIdentitySource(opts)
+	}
+	if usesSAML(opts) {
+		source = "saml:" + samlAssertionSource(opts)
+	}
+	return cacheKey{
+		SourceProfile:  source,
+		RoleArns:       opts.RoleArns,
+		Region:         opts.Region,
+		Duration:       opts.Duration,
+		ExternalID:     opts.ExternalID,
+		SessionName:    opts.SessionName,
+		SourceIdentity: opts.SourceIdentity,
+		Tags:           opts.Tags,
+		TransitiveTags: opts.TransitiveTags,
+		PolicyArns:     opts.PolicyArns,
+	}
+}
+
+// mfaSessionCacheKey returns the cache key of the MFA session of profile
+func mfaSessionCacheKey(profile string) cacheKey {
+	return cacheKey{SourceProfile: cacheSourceName(profile), MFASession: true}
+}
+
+// cacheSourceName names the identity behind profile so sessions of
+// different source credentials never share a cache entry
+func cacheSourceName(profile string) string {
+	if profile == "" && os.Getenv("AWS_ACCESS_KEY_ID") != "" {
+		return "env:" + os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	return effectiveProfile(profile)
+}
+
+// credentialCacheDir returns the directory holding cached credentials
+func credentialCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "awsomecreds", "credentials")
+}
+
+// path returns the cache file for the key
+func (k cacheKey) path() string {
+	data, _ := json.Marshal(k)
+	sum := sha256.Sum256(data)
+	return filepath.Join(credentialCacheDir(), hex.EncodeToString(sum[:])+".json")
+}
+
+// loadCachedCredentials returns the cached credentials for key, or nil if
+// there are none or they expire within refreshWindow
+func loadCachedCredentials(key cacheKey, refreshWindow time.Duration) *Credentials {
+	data, err := os.ReadFile(key.path())
+	if err != nil {
+		return nil
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || !reflect.DeepEqual(entry.Key, key) {
+		return nil
+	}
+	if time.Until(entry.Credentials.Expiration) <= cappedRefreshWindow(refreshWindow, entry.CachedAt, entry.Credentials.Expiration) {
+		return nil
+	}
+	return &entry.Credentials
+}
+
+// cappedRefreshWindow limits window to half the lifetime of credentials
+// issued at issued, so sessions no longer than the window are still reused
+func cappedRefreshWindow(window time.Duration, issued, expiration time.Time) time.Duration {
+	if issued.IsZero() {
+		return window
+	}
+	if half := expiration.Sub(issued) / 2; window > half {
+		return half
+	}
+	return window
+}
+
+// saveCachedCredentials stores credentials for key, readable only by the current user
+func saveCachedCredentials(key cacheKey, credentials *Credentials) error {
+	data, err := json.MarshalIndent(cacheEntry{
+		Key:         key,
+		Credentials: *credentials,
+		CachedAt:    time.Now().UTC(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(key.path(), data, 0600)
+}
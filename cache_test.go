@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// Test that cached credentials are reused until they enter the refresh window
+func TestCredentialCache(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	key := cacheKey{SourceProfile: "source-profile", RoleArns: []string{"arn:aws:iam::123456789012:role/TestRole"}}
+	creds := &Credentials{
+		AccessKeyId:     "ASIACACHED",
+		SecretAccessKey: "secret",
+		SessionToken:    "token",
+		Expiration:      time.Now().Add(50 * time.Minute),
+	}
+	if err := saveCachedCredentials(key, creds); err != nil {
+		t.Fatalf("saveCachedCredentials failed: %v", err)
+	}
+
+	// The cache file must only be readable by the current user
+	info, err := os.Stat(key.path())
+	if err != nil {
+		t.Fatalf("Failed to stat cache file: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected cache file permissions 0600, got %o", info.Mode().Perm())
+	}
+
+	if cached := loadCachedCredentials(key, 15*time.Minute); cached == nil || cached.AccessKeyId != "ASIACACHED" {
+		t.Errorf("Expected cached credentials, got %+v", cached)
+	}
+	// Credentials issued long ago are no longer reused inside the refresh window
+	var entry cacheEntry
+	data, _ := os.ReadFile(key.path())
+	json.Unmarshal(data, &entry)
+	entry.CachedAt = time.Now().Add(-10 * time.Hour)
+	data, _ = json.Marshal(entry)
+	os.WriteFile(key.path(), data, 0600)
+	if cached := loadCachedCredentials(key, time.Hour); cached != nil {
+		t.Errorf("Expected no credentials inside the refresh window, got %+v", cached)
+	}
+
+	other := key
+	other.Region = "us-west-2"
+	if cached := loadCachedCredentials(other, 15*time.Minute); cached != nil {
+		t.Errorf("Expected no credentials for a different key, got %+v", cached)
+	}
+}
+
+// Test that the refresh window is capped at half the lifetime of the credentials
+func TestCappedRefreshWindow(t *testing.T) {
+	issued := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name     string
+		issued   time.Time
+		lifetime time.Duration
+		window   time.Duration
+		expected time.Duration
+	}{
+		{name: "Long session", issued: issued, lifetime: time.Hour, window: 15 * time.Minute, expected: 15 * time.Minute},
+		{name: "Session as long as the window", issued: issued, lifetime: 15 * time.Minute, window: 15 * time.Minute, expected: 450 * time.Second},
+		{name: "Unknown issue time", lifetime: 15 * time.Minute, window: 15 * time.Minute, expected: 15 * time.Minute},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := cappedRefreshWindow(tc.window, tc.issued, issued.Add(tc.lifetime)); got != tc.expected {
+				t.Errorf("Expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+
+	// A 15 minute session saved to the cache is reused with the default window
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	key := cacheKey{SourceProfile: "source-profile", Duration: 900}
+	if err := saveCachedCredentials(key, &Credentials{AccessKeyId: "ASIASHORT", Expiration: time.Now().Add(15 * time.Minute)}); err != nil {
+		t.Fatalf("saveCachedCredentials failed: %v", err)
+	}
+	if cached := loadCachedCredentials(key, defaultRefreshWindow); cached == nil {
+		t.Errorf("Expected a fresh short session to be reused")
+	}
+}
+
+// Test that obtainCredentials honours the cache flags
+func TestObtainCredentialsCache(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	newMockSTSServer(t, map[string]string{"AssumeRole": mockAssumeRoleResponse})
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	opts := &roleOptions{
+		RoleArns:      []string{"arn:aws:iam::123456789012:role/TestRole"},
+		Duration:      3600,
+		RefreshWindow: defaultRefreshWindow,
+	}
+	err := saveCachedCredentials(newCacheKey(opts), &Credentials{
+		AccessKeyId: "ASIACACHED",
+		Expiration:  time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("saveCachedCredentials failed: %v", err)
+	}
+
+	testCases := []struct {
+		name              string
+		noCache           bool
+		forceRefresh      bool
+		expectedAccessKey string
+	}{
+		{name: "cache hit", expectedAccessKey: "ASIACACHED"},
+		{name: "no cache", noCache: true, expectedAccessKey: "ASIAMOCK123456789012"},
+		{name: "force refresh", forceRefresh: true, expectedAccessKey: "ASIAMOCK123456789012"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts.NoCache = tc.noCache
+			opts.ForceRefresh = tc.forceRefresh
+
+			creds, err := obtainCredentials(opts, io.Discard)
+			if err != nil {
+				t.Fatalf("obtainCredentials failed: %v", err)
+			}
+			if creds.AccessKeyId != tc.expectedAccessKey {
+				t.Errorf("Expected AccessKeyId '%s', got '%s'", tc.expectedAccessKey, creds.AccessKeyId)
+			}
+		})
+	}
+}
+
+// Test that the duration and the external ID in effect are part of the cache key
+func TestObtainCredentialsCacheKey(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	newMockSTSServer(t, map[string]string{"AssumeRole": mockAssumeRoleResponse})
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", writeTestFile(t, "credentials", "[vendor]\naws_access_key_id = AKIDTEST\naws_secret_access_key = secret\n"))
+
+	cached := roleOptions{
+		SourceProfile: "vendor",
+		RoleArns:      []string{"arn:aws:iam::123456789012:role/VendorRole"},
+		Duration:      3600,
+		ExternalID:    "from-profile",
+	}
+	err := saveCachedCredentials(newCacheKey(&cached), &Credentials{
+		AccessKeyId: "ASIACACHED",
+		Expiration:  time.Now().Add(30 * time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("saveCachedCredentials failed: %v", err)
+	}
+
+	testCases := []struct {
+		name              string
+		duration          int
+		externalID        string
+		expectedAccessKey string
+	}{
+		{name: "same duration and external ID", duration: 3600, externalID: "from-profile", expectedAccessKey: "ASIACACHED"},
+		{name: "longer duration", duration: 43200, externalID: "from-profile", expectedAccessKey: "ASIAMOCK123456789012"},
+		{name: "external ID edited in the profile", duration: 3600, externalID: "edited", expectedAccessKey: "ASIAMOCK123456789012"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("AWS_CONFIG_FILE", writeTestFile(t, "config", "[profile vendor]\nexternal_id = "+tc.externalID+"\n"))
+			opts := &roleOptions{
+				SourceProfile: "vendor",
+				RoleArns:      cached.RoleArns,
+				Duration:      tc.duration,
+				RefreshWindow: defaultRefreshWindow,
+			}
+
+			creds, err := obtainCredentials(opts, io.Discard)
+			if err != nil {
+				t.Fatalf("obtainCredentials failed: %v", err)
+			}
+			if creds.AccessKeyId != tc.expectedAccessKey {
+				t.Errorf("Expected AccessKeyId '%s', got '%s'", tc.expectedAccessKey, creds.AccessKeyId)
+			}
+		})
+	}
+}
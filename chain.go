@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxChainedDuration is the longest session STS allows for role chaining
+const maxChainedDuration = 3600
+
+// roleHop is one role in a chain of role assumptions
+type roleHop struct {
+	RoleArn    string
+	ExternalID string
+	MFA        bool
+}
+
+// parseRoleHop parses a --role-arn value of the form ARN[#option,...] where
+// the options are external-id=ID and mfa. '#' never appears in a role ARN.
+func parseRoleHop(spec string) (roleHop, error) {
+	arn, options, _ := strings.Cut(spec, "#")
+	hop := roleHop{RoleArn: strings.TrimSpace(arn)}
+	if !strings.HasPrefix(hop.RoleArn, "arn:") {
+		return hop, fmt.Errorf("invalid role ARN %q", hop.RoleArn)
+	}
+
+	if options == "" {
+		return hop, nil
+	}
+	for _, option := range strings.Split(options, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(option), "=")
+		switch name {
+		case "external-id":
+			if value == "" {
+				return hop, fmt.Errorf("empty external-id for role %s", hop.RoleArn)
+			}
+			hop.ExternalID = value
+		case "mfa":
+			hop.MFA = true
+		default:
+			return hop, fmt.Errorf("unknown option %q for role %s (expected external-id=ID or mfa)", option, hop.RoleArn)
+		}
+	}
+	return hop, nil
+}
+
+// parseRoleHops parses every --role-arn value. When no hop asks for MFA
+// but a token was given, the first hop uses it as before role chaining.
+func parseRoleHops(specs []string, haveMFAToken bool) ([]roleHop, error) {
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("at least one role ARN is required")
+	}
+
+	hops := make([]roleHop, 0, len(specs))
+	anyMFA := false
+	for _, spec := range specs {
+		hop, err := parseRoleHop(spec)
+		if err != nil {
+			return nil, err
+		}
+		anyMFA = anyMFA || hop.MFA
+		hops = append(hops, hop)
+	}
+
+	if !anyMFA && haveMFAToken {
+		hops[0].MFA = true
+	}
+	return hops, nil
+}
+
+// roleArnsOf returns the role ARNs of hops
+func roleArnsOf(hops []roleHop) []string {
+	arns := make([]string, len(hops))
+	for i, hop := range hops {
+		arns[i] = hop.RoleArn
+	}
+	return arns
+}
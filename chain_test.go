@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test parsing of --role-arn values with per-hop options
+func TestParseRoleHop(t *testing.T) {
+	testCases := []struct {
+		spec        string
+		expected    roleHop
+		expectedErr bool
+	}{
+		{spec: "arn:aws:iam::123456789012:role/hub", expected: roleHop{RoleArn: "arn:aws:iam::123456789012:role/hub"}},
+		{spec: "arn:aws:iam::123456789012:role/hub#mfa", expected: roleHop{RoleArn: "arn:aws:iam::123456789012:role/hub", MFA: true}},
+		{
+			spec:     "arn:aws:iam::123456789012:role/vendor#external-id=abc,mfa",
+			expected: roleHop{RoleArn: "arn:aws:iam::123456789012:role/vendor", ExternalID: "abc", MFA: true},
+		},
+		{spec: "my-role", expectedErr: true},
+		{spec: "arn:aws:iam::123456789012:role/hub#external-id=", expectedErr: true},
+		{spec: "arn:aws:iam::123456789012:role/hub#bogus", expectedErr: true},
+	}
+
+	for _, tc := range testCases {
+		hop, err := parseRoleHop(tc.spec)
+		if (err != nil) != tc.expectedErr {
+			t.Errorf("parseRoleHop(%q) error = %v, expectedErr %v", tc.spec, err, tc.expectedErr)
+			continue
+		}
+		if !tc.expectedErr && hop != tc.expected {
+			t.Errorf("parseRoleHop(%q) = %+v, expected %+v", tc.spec, hop, tc.expected)
+		}
+	}
+}
+
+// Test that a legacy MFA token applies to the first hop when no hop asks for MFA
+func TestParseRoleHopsDefaultMFA(t *testing.T) {
+	hops, err := parseRoleHops([]string{"arn:aws:iam::111111111111:role/hub", "arn:aws:iam::222222222222:role/workload"}, true)
+	if err != nil {
+		t.Fatalf("parseRoleHops failed: %v", err)
+	}
+	if !hops[0].MFA || hops[1].MFA {
+		t.Errorf("Expected only the first hop to use MFA, got %+v", hops)
+	}
+}
+
+// Test that every hop is signed with the previous hop's credentials
+func TestObtainCredentialsChain(t *testing.T) {
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		auth := r.Header.Get("Authorization")
+		signer := strings.SplitN(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 Credential="), "/", 2)[0]
+		role := r.PostForm.Get("RoleArn")
+		calls = append(calls, fmt.Sprintf("%s %s %s %s", signer, role, r.PostForm.Get("DurationSeconds"), r.PostForm.Get("ExternalId")))
+
+		name := role[strings.LastIndex(role, "/")+1:]
+		fmt.Fprintf(w, `<AssumeRoleResponse><AssumeRoleResult><Credentials>
+			<AccessKeyId>ASIA%s</AccessKeyId><SecretAccessKey>secret</SecretAccessKey>
+			<SessionToken>token</SessionToken><Expiration>2030-01-01T00:00:00Z</Expiration>
+			</Credentials></AssumeRoleResult></AssumeRoleResponse>`, strings.ToUpper(name))
+	}))
+	defer server.Close()
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	opts := &roleOptions{
+		RoleArns: []string{
+			"arn:aws:iam::111111111111:role/hub",
+			"arn:aws:iam::222222222222:role/workload#external-id=xyz",
+		},
+		Duration: 7200,
+		NoCache:  true,
+	}
+	creds, err := obtainCredentials(opts, io.Discard)
+	if err != nil {
+		t.Fatalf("obtainCredentials failed: %v", err)
+	}
+
+	expected := []string{
+		"AKIDTEST arn:aws:iam::111111111111:role/hub 3600 ",
+		"ASIAHUB arn:aws:iam::222222222222:role/workload 3600 xyz",
+	}
+	if strings.Join(calls, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected AssumeRole calls:\n%s\nexpected:\n%s", strings.Join(calls, "\n"), strings.Join(expected, "\n"))
+	}
+	if creds.AccessKeyId != "ASIAWORKLOAD" {
+		t.Errorf("Expected AccessKeyId 'ASIAWORKLOAD', got '%s'", creds.AccessKeyId)
+	}
+}
+
+// Test that the MFA token is used once and later MFA hops prompt for a fresh code
+func TestObtainCredentialsChainMFA(t *testing.T) {
+	var codes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("Action") == "ListMFADevices" {
+			fmt.Fprint(w, `<ListMFADevicesResponse><ListMFADevicesResult><MFADevices>
+				<member><SerialNumber>arn:aws:iam::111111111111:mfa/alice</SerialNumber></member>
+				</MFADevices><IsTruncated>false</IsTruncated></ListMFADevicesResult></ListMFADevicesResponse>`)
+			return
+		}
+		codes = append(codes, r.PostForm.Get("SerialNumber")+" "+r.PostForm.Get("TokenCode"))
+		fmt.Fprint(w, mockAssumeRoleResponse)
+	}))
+	defer server.Close()
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	var prompted []string
+	originalReadMFACode := readMFACode
+	readMFACode = func(device string) (string, error) {
+		prompted = append(prompted, device)
+		return "654321", nil
+	}
+	defer func() { readMFACode = originalReadMFACode }()
+
+	opts := &roleOptions{
+		RoleArns: []string{"arn:aws:iam::111111111111:role/hub#mfa", "arn:aws:iam::222222222222:role/workload#mfa"},
+		MFAToken: "123456",
+		Duration: 3600,
+		NoCache:  true,
+	}
+	if _, err := obtainCredentials(opts, io.Discard); err != nil {
+		t.Fatalf("obtainCredentials failed: %v", err)
+	}
+
+	expected := "arn:aws:iam::111111111111:mfa/alice 123456\narn:aws:iam::111111111111:mfa/alice 654321"
+	if strings.Join(codes, "\n") != expected {
+		t.Errorf("Unexpected MFA codes:\n%s\nexpected:\n%s", strings.Join(codes, "\n"), expected)
+	}
+	if len(prompted) != 1 || prompted[0] != "arn:aws:iam::111111111111:mfa/alice" {
+		t.Errorf("Expected one prompt for the MFA device, got %v", prompted)
+	}
+}
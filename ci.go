@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// writeGitHubEnv appends the credential variables to the $GITHUB_ENV file, so
+// later steps of the job get them, and masks the secrets first by writing
+// add-mask commands to w, the step's stdout
+func writeGitHubEnv(w io.Writer, credentials *Credentials, region string) error {
+	path := os.Getenv("GITHUB_ENV")
+	if path == "" {
+		return fmt.Errorf("GITHUB_ENV is not set, -o github only works in GitHub Actions")
+	}
+
+	for _, secret := range []string{credentials.AccessKeyId, credentials.SecretAccessKey, credentials.SessionToken} {
+		fmt.Fprintf(w, "::add-mask::%s\n", escapeWorkflowData(secret))
+	}
+
+	var b strings.Builder
+	for _, kv := range credentialVariables(credentials, region) {
+		if strings.ContainsAny(kv[1], "\r\n") {
+			// Multiline values need the heredoc syntax with a delimiter that can't occur in them
+			delimiter, err := randomToken()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&b, "%s<<ghadelimiter_%s\n%s\nghadelimiter_%s\n", kv[0], delimiter, kv[1], delimiter)
+		} else {
+			fmt.Fprintf(&b, "%s=%s\n", kv[0], kv[1])
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open GITHUB_ENV: %w", err)
+	}
+	if _, err := file.WriteString(b.String()); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write GITHUB_ENV: %w", err)
+	}
+	return file.Close()
+}
+
+// escapeWorkflowData escapes the data of a GitHub Actions workflow command
+func escapeWorkflowData(value string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(value)
+}
+
+// writeDotenv writes the credential variables as KEY=VALUE lines, the format
+// of docker run --env-file and GitLab dotenv reports. Neither supports
+// quoting or multiline values, so values are written verbatim.
+func writeDotenv(w io.Writer, credentials *Credentials, region string) error {
+	variables := credentialVariables(credentials, region)
+	for _, kv := range variables {
+		if strings.ContainsAny(kv[1], "\r\n") {
+			return fmt.Errorf("cannot write %s: dotenv files don't support multiline values", kv[0])
+		}
+	}
+	for _, kv := range variables {
+		fmt.Fprintf(w, "%s=%s\n", kv[0], kv[1])
+	}
+	return nil
+}
+
+// checkNotJobLog refuses to write credentials to out in a GitLab or GitHub
+// job unless out is a file. Dotenv values can't be masked, so on stdout they
+// would end up in the job log.
+func checkNotJobLog(out *os.File) error {
+	if os.Getenv("GITLAB_CI") == "" && os.Getenv("GITHUB_ACTIONS") == "" {
+		return nil
+	}
+	info, err := out.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return fmt.Errorf("refusing to write credentials to the job log, redirect the output to a file (e.g. > build.env)")
+	}
+	return nil
+}
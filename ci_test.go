@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCICredentials are credentials with a secret that needs escaping in workflow commands
+var testCICredentials = &Credentials{
+	AccessKeyId:     "ASIAMOCK",
+	SecretAccessKey: "secret%key",
+	SessionToken:    "token",
+	Expiration:      time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+}
+
+// Test that GitHub output masks the secrets and appends the variables to $GITHUB_ENV
+func TestWriteGitHubEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "github_env")
+	os.WriteFile(path, []byte("EXISTING=1\n"), 0600)
+	t.Setenv("GITHUB_ENV", path)
+
+	var out bytes.Buffer
+	if err := writeGitHubEnv(&out, testCICredentials, "us-east-1"); err != nil {
+		t.Fatalf("writeGitHubEnv failed: %v", err)
+	}
+
+	expectedMasks := "::add-mask::ASIAMOCK\n::add-mask::secret%25key\n::add-mask::token\n"
+	if out.String() != expectedMasks {
+		t.Errorf("Unexpected stdout:\n%s\nexpected:\n%s", out.String(), expectedMasks)
+	}
+	env, _ := os.ReadFile(path)
+	expectedEnv := "EXISTING=1\nAWS_ACCESS_KEY_ID=ASIAMOCK\nAWS_SECRET_ACCESS_KEY=secret%key\nAWS_SESSION_TOKEN=token\n" +
+		"AWS_REGION=us-east-1\nAWS_DEFAULT_REGION=us-east-1\nAWS_CREDENTIAL_EXPIRATION=2030-01-01T00:00:00Z\n"
+	if string(env) != expectedEnv {
+		t.Errorf("Unexpected GITHUB_ENV:\n%s\nexpected:\n%s", env, expectedEnv)
+	}
+}
+
+// Test that multiline values use the heredoc syntax and that GITHUB_ENV is required
+func TestWriteGitHubEnvMultiline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "github_env")
+	t.Setenv("GITHUB_ENV", path)
+
+	credentials := *testCICredentials
+	credentials.SessionToken = "line1\nline2"
+	if err := writeGitHubEnv(&bytes.Buffer{}, &credentials, ""); err != nil {
+		t.Fatalf("writeGitHubEnv failed: %v", err)
+	}
+	env, _ := os.ReadFile(path)
+	lines := strings.Split(string(env), "\n")
+	if !strings.HasPrefix(lines[2], "AWS_SESSION_TOKEN<<ghadelimiter_") || lines[3] != "line1" || lines[4] != "line2" || lines[5] != strings.TrimPrefix(lines[2], "AWS_SESSION_TOKEN<<") {
+		t.Errorf("Unexpected GITHUB_ENV:\n%s", env)
+	}
+
+	t.Setenv("GITHUB_ENV", "")
+	var out bytes.Buffer
+	if err := writeGitHubEnv(&out, testCICredentials, ""); err == nil || out.Len() > 0 {
+		t.Errorf("Expected an error and no output without GITHUB_ENV, got %v and %q", err, out.String())
+	}
+}
+
+// Test the dotenv format
+func TestWriteDotenv(t *testing.T) {
+	var out bytes.Buffer
+	if err := writeDotenv(&out, testCICredentials, ""); err != nil {
+		t.Fatalf("writeDotenv failed: %v", err)
+	}
+	expected := "AWS_ACCESS_KEY_ID=ASIAMOCK\nAWS_SECRET_ACCESS_KEY=secret%key\nAWS_SESSION_TOKEN=token\nAWS_CREDENTIAL_EXPIRATION=2030-01-01T00:00:00Z\n"
+	if out.String() != expected {
+		t.Errorf("Unexpected output:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+
+	credentials := *testCICredentials
+	credentials.SessionToken = "line1\nline2"
+	out.Reset()
+	if err := writeDotenv(&out, &credentials, ""); err == nil || out.Len() > 0 {
+		t.Errorf("Expected multiline values to be refused without output, got %v and %q", err, out.String())
+	}
+}
+
+// Test that dotenv output in a CI job must go to a file
+func TestCheckNotJobLog(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "build.env"))
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	defer file.Close()
+	r, w, _ := os.Pipe()
+	defer r.Close()
+	defer w.Close()
+
+	t.Setenv("GITLAB_CI", "")
+	t.Setenv("GITHUB_ACTIONS", "")
+	if err := checkNotJobLog(w); err != nil {
+		t.Errorf("Expected output outside of CI to be allowed, got %v", err)
+	}
+
+	for _, variable := range []string{"GITLAB_CI", "GITHUB_ACTIONS"} {
+		t.Setenv(variable, "true")
+		if err := checkNotJobLog(w); err == nil {
+			t.Errorf("Expected output to a pipe to be refused with %s set", variable)
+		}
+		if err := checkNotJobLog(file); err != nil {
+			t.Errorf("Expected output to a file to be allowed with %s set, got %v", variable, err)
+		}
+		t.Setenv(variable, "")
+	}
+}
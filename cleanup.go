@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+)
+
+// managedCredentialKeys and managedConfigKeys are the settings
+// configureAWSProfile writes
+var (
+	managedCredentialKeys = []string{"aws_access_key_id", "aws_secret_access_key", "aws_session_token"}
+	managedConfigKeys     = []string{"region"}
+)
+
+// cleanupOptions selects the managed profiles to remove
+type cleanupOptions struct {
+	// All removes every managed profile instead of only the expired ones
+	All bool
+	// Profiles removes the named managed profiles whether or not they expired
+	Profiles []string
+	DryRun   bool
+}
+
+// cleanupProfiles removes managed profiles that expired before now, or the
+// ones opts selects, from the shared credentials and config files. Only
+// settings awsomecreds wrote are removed, and every other section is left as
+// it was. Progress is reported to out.
+func cleanupProfiles(opts *cleanupOptions, out io.Writer, now time.Time) error {
+	return withSharedFilesLock(func() error {
+		return removeManagedProfiles(opts, out, now)
+	})
+}
+
+// removeManagedProfiles does the work of cleanupProfiles, with the shared files locked
+func removeManagedProfiles(opts *cleanupOptions, out io.Writer, now time.Time) error {
+	profiles, err := loadManagedProfiles()
+	if err != nil {
+		return err
+	}
+
+	managed := make(map[string]bool)
+	for _, profile := range profiles {
+		managed[profile.Profile] = true
+	}
+	for _, name := range opts.Profiles {
+		if !managed[name] {
+			return fmt.Errorf("profile %s was not generated by awsomecreds, leaving it alone", name)
+		}
+	}
+
+	credentialsPath, configPath := sharedCredentialsFile(), sharedConfigFile()
+	credentialsFile, err := loadINIFile(credentialsPath)
+	if err != nil {
+		return err
+	}
+	configFile, err := loadINIFile(configPath)
+	if err != nil {
+		return err
+	}
+	credentialsBefore, configBefore := credentialsFile.bytes(), configFile.bytes()
+
+	action := "Removed"
+	if opts.DryRun {
+		action = "Would remove"
+	}
+	var kept []managedProfile
+	removed := 0
+	for _, profile := range profiles {
+		selected := !now.Before(profile.Expiration)
+		if opts.All {
+			selected = true
+		} else if len(opts.Profiles) > 0 {
+			selected = containsString(opts.Profiles, profile.Profile)
+		}
+		if !selected {
+			kept = append(kept, profile)
+			continue
+		}
+
+		removed++
+		if credentialsFile.hasSection(profile.Profile) && credentialsFile.values(profile.Profile)["aws_access_key_id"] != profile.AccessKeyId {
+			fmt.Fprintf(out, "Forgetting profile %s, its credentials were replaced since awsomecreds wrote them\n", profile.Profile)
+			continue
+		}
+		removeManagedSettings(credentialsFile, profile.Profile, managedCredentialKeys, profile.CredentialsSection)
+		removeManagedSettings(configFile, configSectionName(profile.Profile), managedConfigKeys, profile.ConfigSection)
+		fmt.Fprintf(out, "%s profile %s (expires %s)\n", action, profile.Profile, profile.Expiration.Local().Format("2006-01-02 15:04:05 MST"))
+	}
+
+	if removed == 0 {
+		fmt.Fprintf(out, "No profiles to remove\n")
+		return nil
+	}
+	if opts.DryRun {
+		return nil
+	}
+
+	// Files without managed settings are not rewritten at all
+	if !bytes.Equal(credentialsFile.bytes(), credentialsBefore) {
+		if err := credentialsFile.save(credentialsPath); err != nil {
+			return err
+		}
+	}
+	if !bytes.Equal(configFile.bytes(), configBefore) {
+		if err := configFile.save(configPath); err != nil {
+			return err
+		}
+	}
+	return saveManagedProfiles(kept)
+}
+
+// cleanupOutFiles deletes the files written with --out-file-ttl that expired
+// before now, or all of them with opts.All. Selecting profiles leaves them alone.
+func cleanupOutFiles(opts *cleanupOptions, out io.Writer, now time.Time) error {
+	if len(opts.Profiles) > 0 {
+		return nil
+	}
+	return removeOutFiles(now, opts.All, opts.DryRun, func(format string, args ...interface{}) {
+		fmt.Fprintf(out, format+"\n", args...)
+	})
+}
+
+// removeManagedSettings undoes what write describes to section name. A
+// section awsomecreds created is removed if it holds nothing but keys, with
+// the blank line added before it, and otherwise loses only keys. In a section
+// that existed before, keys that were replaced get their old lines back and
+// the others are removed, so the section stays as it was written by hand.
+func removeManagedSettings(file *iniFile, name string, keys []string, write sectionWrite) {
+	if !file.hasSection(name) {
+		return
+	}
+	if !write.Created {
+		for _, key := range keys {
+			if raw, ok := write.Previous[key]; ok {
+				file.replaceLine(name, key, raw)
+			} else {
+				file.removeKeys(name, key)
+			}
+		}
+		return
+	}
+	for key := range file.values(name) {
+		if !containsString(keys, key) {
+			file.removeKeys(name, keys...)
+			return
+		}
+	}
+	file.removeSection(name, write.Separated)
+}
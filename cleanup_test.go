@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// userCredentials and userConfig are sections written by hand, which cleanup must never change
+const (
+	userCredentials = "# my keys\n[default]\naws_access_key_id = AKIAUSER\naws_secret_access_key = secret ; inline\n\n"
+	userConfig      = "[default]\nregion = eu-central-1\ns3 =\n  max_concurrent_requests = 20\n\n"
+)
+
+// setupCleanupTest writes the user's files and the managed profiles old,
+// current and mixed (which also has a setting added by hand)
+func setupCleanupTest(t *testing.T, now time.Time) (credentialsPath, configPath string) {
+	t.Helper()
+	dir := t.TempDir()
+	credentialsPath, configPath = filepath.Join(dir, "credentials"), filepath.Join(dir, "config")
+	os.WriteFile(credentialsPath, []byte(userCredentials), 0600)
+	os.WriteFile(configPath, []byte(userConfig), 0600)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentialsPath)
+	t.Setenv("AWS_CONFIG_FILE", configPath)
+
+	for _, p := range []struct {
+		name       string
+		expiration time.Time
+	}{
+		{"old", now.Add(-time.Hour)},
+		{"current", now.Add(time.Hour)},
+		{"mixed", now.Add(-time.Minute)},
+	} {
+		credentials := &Credentials{AccessKeyId: "ASIA" + strings.ToUpper(p.name), SecretAccessKey: "secret", SessionToken: "token", Expiration: p.expiration}
+		write, err := configureAWSProfile(p.name, credentials, "", "us-west-2", io.Discard)
+		if err != nil {
+			t.Fatalf("configureAWSProfile failed: %v", err)
+		}
+		if err := recordManagedProfile(p.name, &roleOptions{RoleArns: []string{"arn:aws:iam::123456789012:role/" + p.name}}, credentials, write); err != nil {
+			t.Fatalf("recordManagedProfile failed: %v", err)
+		}
+	}
+	file, _ := loadINIFile(configPath)
+	file.set("profile mixed", "output", "json")
+	file.save(configPath)
+	return credentialsPath, configPath
+}
+
+// Test that cleanup removes expired profiles and leaves everything else byte for byte
+func TestCleanupProfiles(t *testing.T) {
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	credentialsPath, configPath := setupCleanupTest(t, now)
+
+	var out bytes.Buffer
+	if err := cleanupProfiles(&cleanupOptions{}, &out, now); err != nil {
+		t.Fatalf("cleanupProfiles failed: %v", err)
+	}
+
+	credentials, _ := os.ReadFile(credentialsPath)
+	// The blank line added before the current profile stays with it
+	expectedCredentials := userCredentials + "\n[current]\naws_access_key_id = ASIACURRENT\naws_secret_access_key = secret\naws_session_token = token\n"
+	if string(credentials) != expectedCredentials {
+		t.Errorf("Unexpected credentials file:\n%s\nexpected:\n%s", credentials, expectedCredentials)
+	}
+	config, _ := os.ReadFile(configPath)
+	expectedConfig := userConfig + "\n[profile current]\nregion = us-west-2\n\n[profile mixed]\noutput = json\n"
+	if string(config) != expectedConfig {
+		t.Errorf("Unexpected config file:\n%s\nexpected:\n%s", config, expectedConfig)
+	}
+
+	profiles, _ := loadManagedProfiles()
+	if len(profiles) != 1 || profiles[0].Profile != "current" {
+		t.Errorf("Expected only the current profile to stay managed, got %+v", profiles)
+	}
+	if !strings.Contains(out.String(), "Removed profile old") || !strings.Contains(out.String(), "Removed profile mixed") {
+		t.Errorf("Unexpected output:\n%s", out.String())
+	}
+}
+
+// Test that removing every profile restores the files exactly, including
+// files without a trailing blank line and comments above a later section
+func TestCleanupProfilesRestoresFiles(t *testing.T) {
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+	credentialsPath, configPath := filepath.Join(dir, "credentials"), filepath.Join(dir, "config")
+	originalCredentials := "[default]\naws_access_key_id = AKIAUSER\naws_secret_access_key = secret\n"
+	originalConfig := "[profile prod]\nregion = us-east-1\n"
+	os.WriteFile(credentialsPath, []byte(originalCredentials), 0600)
+	os.WriteFile(configPath, []byte(originalConfig), 0600)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentialsPath)
+	t.Setenv("AWS_CONFIG_FILE", configPath)
+
+	for _, name := range []string{"first", "second"} {
+		credentials := &Credentials{AccessKeyId: "ASIA" + strings.ToUpper(name), SecretAccessKey: "secret", SessionToken: "token", Expiration: now.Add(-time.Hour)}
+		write, err := configureAWSProfile(name, credentials, "", "us-west-2", io.Discard)
+		if err != nil {
+			t.Fatalf("configureAWSProfile failed: %v", err)
+		}
+		if err := recordManagedProfile(name, &roleOptions{}, credentials, write); err != nil {
+			t.Fatalf("recordManagedProfile failed: %v", err)
+		}
+	}
+
+	// A section added by hand after the generated ones, with a comment above it
+	staging := "# my staging keys\n[staging]\naws_access_key_id = AKIASTAGING\n"
+	data, _ := os.ReadFile(credentialsPath)
+	os.WriteFile(credentialsPath, append(data, staging...), 0600)
+
+	if err := cleanupProfiles(&cleanupOptions{}, &bytes.Buffer{}, now); err != nil {
+		t.Fatalf("cleanupProfiles failed: %v", err)
+	}
+
+	credentials, _ := os.ReadFile(credentialsPath)
+	if expected := originalCredentials + staging; string(credentials) != expected {
+		t.Errorf("Unexpected credentials file:\n%q\nexpected:\n%q", credentials, expected)
+	}
+	config, _ := os.ReadFile(configPath)
+	if string(config) != originalConfig {
+		t.Errorf("Unexpected config file:\n%q\nexpected:\n%q", config, originalConfig)
+	}
+}
+
+// Test that sections written by hand before generate-profile wrote to them
+// get their own lines back instead of being removed
+func TestCleanupProfilesExistingSections(t *testing.T) {
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+	credentialsPath, configPath := filepath.Join(dir, "credentials"), filepath.Join(dir, "config")
+	originalCredentials := "[work]\n# mine\naws_access_key_id=AKIAWORK\n"
+	originalConfig := "[profile work]\nregion=eu-west-1\noutput = json\n\n[profile home]\nregion = eu-west-1\n"
+	os.WriteFile(credentialsPath, []byte(originalCredentials), 0600)
+	os.WriteFile(configPath, []byte(originalConfig), 0600)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentialsPath)
+	t.Setenv("AWS_CONFIG_FILE", configPath)
+
+	// Each profile is written twice, so the second write sees awsomecreds' own values
+	for _, name := range []string{"work", "home", "work", "home"} {
+		credentials := &Credentials{AccessKeyId: "ASIA" + strings.ToUpper(name), SecretAccessKey: "secret", SessionToken: "token", Expiration: now.Add(-time.Hour)}
+		write, err := configureAWSProfile(name, credentials, "", "us-west-2", io.Discard)
+		if err != nil {
+			t.Fatalf("configureAWSProfile failed: %v", err)
+		}
+		if err := recordManagedProfile(name, &roleOptions{}, credentials, write); err != nil {
+			t.Fatalf("recordManagedProfile failed: %v", err)
+		}
+	}
+	if value, _ := loadINIFileValue(t, configPath, "profile home", "region"); value != "us-west-2" {
+		t.Fatalf("Expected the generated region to be written, got '%s'", value)
+	}
+
+	if err := cleanupProfiles(&cleanupOptions{}, &bytes.Buffer{}, now); err != nil {
+		t.Fatalf("cleanupProfiles failed: %v", err)
+	}
+
+	credentials, _ := os.ReadFile(credentialsPath)
+	if string(credentials) != originalCredentials {
+		t.Errorf("Unexpected credentials file:\n%q\nexpected:\n%q", credentials, originalCredentials)
+	}
+	config, _ := os.ReadFile(configPath)
+	if string(config) != originalConfig {
+		t.Errorf("Unexpected config file:\n%q\nexpected:\n%q", config, originalConfig)
+	}
+}
+
+// Test that a dry run changes nothing
+func TestCleanupProfilesDryRun(t *testing.T) {
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	credentialsPath, configPath := setupCleanupTest(t, now)
+	credentialsBefore, _ := os.ReadFile(credentialsPath)
+	configBefore, _ := os.ReadFile(configPath)
+	registryBefore, _ := os.ReadFile(profileRegistryFile())
+
+	var out bytes.Buffer
+	if err := cleanupProfiles(&cleanupOptions{All: true, DryRun: true}, &out, now); err != nil {
+		t.Fatalf("cleanupProfiles failed: %v", err)
+	}
+
+	credentials, _ := os.ReadFile(credentialsPath)
+	config, _ := os.ReadFile(configPath)
+	registry, _ := os.ReadFile(profileRegistryFile())
+	if !bytes.Equal(credentials, credentialsBefore) || !bytes.Equal(config, configBefore) || !bytes.Equal(registry, registryBefore) {
+		t.Errorf("Expected a dry run to change nothing")
+	}
+	if strings.Count(out.String(), "Would remove profile") != 3 {
+		t.Errorf("Unexpected output:\n%s", out.String())
+	}
+}
+
+// Test removing named profiles, and that unmanaged or replaced profiles are left alone
+func TestCleanupProfilesByName(t *testing.T) {
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	credentialsPath, _ := setupCleanupTest(t, now)
+
+	if err := cleanupProfiles(&cleanupOptions{Profiles: []string{"default"}}, &bytes.Buffer{}, now); err == nil {
+		t.Errorf("Expected an error for a profile awsomecreds did not generate")
+	}
+
+	// Someone replaced the credentials of the current profile by hand
+	file, _ := loadINIFile(credentialsPath)
+	file.set("current", "aws_access_key_id", "AKIAMINE")
+	file.save(credentialsPath)
+
+	var out bytes.Buffer
+	if err := cleanupProfiles(&cleanupOptions{Profiles: []string{"current"}}, &out, now); err != nil {
+		t.Fatalf("cleanupProfiles failed: %v", err)
+	}
+	if value, _ := loadINIFileValue(t, credentialsPath, "current", "aws_access_key_id"); value != "AKIAMINE" {
+		t.Errorf("Expected the replaced credentials to stay, got '%s'", value)
+	}
+	if !strings.Contains(out.String(), "Forgetting profile current") {
+		t.Errorf("Unexpected output:\n%s", out.String())
+	}
+	profiles, _ := loadManagedProfiles()
+	if len(profiles) != 2 || profiles[0].Profile != "mixed" || profiles[1].Profile != "old" {
+		t.Errorf("Expected the expired profiles to stay managed, got %+v", profiles)
+	}
+}
+
+// loadINIFileValue reads one setting of an INI file
+func loadINIFileValue(t *testing.T, path, section, key string) (string, bool) {
+	t.Helper()
+	file, err := loadINIFile(path)
+	if err != nil {
+		t.Fatalf("loadINIFile failed: %v", err)
+	}
+	return file.get(section, key)
+}
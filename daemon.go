@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	// defaultRefreshBefore is how long before expiry the daemon refreshes a profile
+	defaultRefreshBefore = 10 * time.Minute
+	// defaultDaemonInterval is how often the daemon checks the managed profiles
+	defaultDaemonInterval = time.Minute
+)
+
+// errNoPrompt is returned when a refresh would need someone to answer a prompt
+var errNoPrompt = errors.New("the daemon cannot prompt for input")
+
+// daemonOptions holds the settings of the refresh daemon
+type daemonOptions struct {
+	RefreshBefore time.Duration
+	Interval      time.Duration
+	// PIDFile is written at startup and removed at exit, for init systems
+	PIDFile string
+	// NotifyCommand is run with a message when a profile needs a human
+	NotifyCommand string
+	// Once checks the profiles a single time, e.g. from a timer
+	Once bool
+}
+
+// refreshDaemon keeps the managed profiles from expiring
+type refreshDaemon struct {
+	opts *daemonOptions
+	out  io.Writer
+	// notified remembers the expiration each profile was reported for, so it is reported once
+	notified map[string]time.Time
+}
+
+// runRefreshDaemon refreshes managed profiles before they expire until it is
+// stopped with SIGINT or SIGTERM
+func runRefreshDaemon(opts *daemonOptions) error {
+	if opts.Interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+	if opts.PIDFile != "" {
+		if err := writeFileAtomic(opts.PIDFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+			return fmt.Errorf("failed to write PID file: %w", err)
+		}
+		defer os.Remove(opts.PIDFile)
+	}
+
+	// Nobody is there to answer, so refreshes that need input fail instead of hanging
+	readMFACode = func(string) (string, error) { return "", errNoPrompt }
+	readLine = func(string) (string, error) { return "", errNoPrompt }
+
+	d := &refreshDaemon{opts: opts, out: os.Stderr, notified: make(map[string]time.Time)}
+	d.logf("Refreshing managed profiles %s before they expire", opts.RefreshBefore)
+	d.refreshAll()
+	if opts.Once {
+		return nil
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.refreshAll()
+		case <-signals:
+			d.logf("Stopping")
+			return nil
+		}
+	}
+}
+
+// refreshAll refreshes every managed profile that expires within the refresh
+// window and deletes the output files that expired
+func (d *refreshDaemon) refreshAll() {
+	if err := removeOutFiles(time.Now(), false, false, d.logf); err != nil {
+		d.logf("Error removing expired files: %v", err)
+	}
+
+	profiles, err := loadManagedProfiles()
+	if err != nil {
+		d.logf("Error loading managed profiles: %v", err)
+		return
+	}
+
+	for _, profile := range profiles {
+		if time.Until(profile.Expiration) > d.opts.RefreshBefore {
+			continue
+		}
+		// Leave profiles alone that were removed by hand
+		if _, found, err := loadProfile(profile.Profile); err == nil && !found {
+			continue
+		}
+		if reason := refreshBlocker(&profile.Options); reason != "" {
+			d.needsHuman(profile, reason)
+			continue
+		}
+
+		credentials, err := refreshManagedProfile(profile)
+		if err != nil {
+			d.logf("Error refreshing profile %s: %v", profile.Profile, err)
+			continue
+		}
+		d.logf("Refreshed profile %s, valid until %s", profile.Profile, credentials.Expiration.Local().Format("2006-01-02 15:04:05 MST"))
+	}
+}
+
+// refreshManagedProfile assumes the role of profile again and rewrites it
+func refreshManagedProfile(profile managedProfile) (*Credentials, error) {
+	opts := profile.Options
+	opts.ForceRefresh = true
+	credentials, err := obtainCredentials(&opts, io.Discard)
+	if err != nil {
+		return nil, err
+	}
+	err = withSharedFilesLock(func() error {
+		// generate-profile or cleanup may have changed the profile while its role was assumed
+		changed, err := managedProfileChanged(profile)
+		if err != nil {
+			return err
+		}
+		if changed {
+			return errManagedProfileChanged
+		}
+		// The daemon only logs its own timestamped lines
+		write, err := configureAWSProfile(profile.Profile, credentials, opts.SourceProfile, opts.Region, io.Discard)
+		if err != nil {
+			return err
+		}
+		return recordManagedProfile(profile.Profile, &profile.Options, credentials, write)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// errManagedProfileChanged is returned when a profile was regenerated or
+// removed while the daemon was refreshing it
+var errManagedProfileChanged = errors.New("the profile was regenerated or removed meanwhile, leaving it alone")
+
+// managedProfileChanged reports whether profile is no longer recorded as it
+// was, or its credentials were removed from the shared credentials file
+func managedProfileChanged(profile managedProfile) (bool, error) {
+	profiles, err := loadManagedProfiles()
+	if err != nil {
+		return false, err
+	}
+	for _, current := range profiles {
+		if current.Profile == profile.Profile {
+			_, found, err := loadProfile(profile.Profile)
+			return current.AccessKeyId != profile.AccessKeyId || !found, err
+		}
+	}
+	return true, nil
+}
+
+// needsHuman reports once per expiration that profile can't be refreshed
+// automatically, and runs the notify command if there is one
+func (d *refreshDaemon) needsHuman(profile managedProfile, reason string) {
+	if d.notified[profile.Profile].Equal(profile.Expiration) {
+		return
+	}
+	d.notified[profile.Profile] = profile.Expiration
+
+	verb := "expires"
+	if time.Now().After(profile.Expiration) {
+		verb = "expired"
+	}
+	message := fmt.Sprintf("Profile %s %s at %s and cannot be refreshed automatically: %s. Run awsomecreds generate-profile -n %s again to refresh it.",
+		profile.Profile, verb, profile.Expiration.Local().Format("2006-01-02 15:04:05 MST"), reason, profile.Profile)
+	d.logf("%s", message)
+
+	if d.opts.NotifyCommand != "" {
+		cmd := execCommand(d.opts.NotifyCommand, message)
+		cmd.Stdout = d.out
+		cmd.Stderr = d.out
+		if err := cmd.Run(); err != nil {
+			d.logf("Error running notify command %s: %v", d.opts.NotifyCommand, err)
+		}
+	}
+}
+
+// logf writes a timestamped line to the daemon log
+func (d *refreshDaemon) logf(format string, args ...interface{}) {
+	fmt.Fprintf(d.out, "%s %s\n", time.Now().Format("2006-01-02 15:04:05"), fmt.Sprintf(format, args...))
+}
+
+// refreshBlocker returns why the role of opts can't be assumed without a
+// human, or "" if it can
+func refreshBlocker(opts *roleOptions) string {
+	if usesSAML(opts) {
+		return "a new SAML assertion is required"
+	}
+	config, err := resolveSSOConfig(opts)
+	sso := err == nil && config != nil
+	if sso {
+		token, err := loadSSOToken(config)
+		if err != nil || token == nil || time.Until(token.ExpiresAt) < time.Minute {
+			return "IAM Identity Center sign-in is required"
+		}
+	}
+	if len(opts.RoleArns) == 0 {
+		return ""
+	}
+
+	hops, err := parseRoleHops(opts.RoleArns, opts.MFAPrompt)
+	if err != nil {
+		// The refresh itself reports the error
+		return ""
+	}
+	mfaHops := 0
+	for _, hop := range hops {
+		if hop.MFA {
+			mfaHops++
+		}
+	}
+	if mfaHops == 0 {
+		return ""
+	}
+
+	// A cached MFA session stands in for the code of the first role
+	if mfaHops == 1 && hops[0].MFA && !sso && !usesWebIdentity(opts) && !opts.NoCache &&
+		loadCachedCredentials(mfaSessionCacheKey(opts.SourceProfile), opts.RefreshWindow) != nil {
+		return ""
+	}
+	return "an MFA code is required"
+}
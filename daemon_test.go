@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test which profiles can be refreshed without a human
+func TestRefreshBlocker(t *testing.T) {
+	testCases := []struct {
+		name     string
+		opts     roleOptions
+		expected string
+	}{
+		{"plain role", roleOptions{RoleArns: []string{"arn:aws:iam::123456789012:role/reader"}}, ""},
+		{"MFA code", roleOptions{RoleArns: []string{"arn:aws:iam::123456789012:role/admin"}, MFAPrompt: true}, "an MFA code is required"},
+		{"MFA hop", roleOptions{RoleArns: []string{"arn:aws:iam::123456789012:role/hub", "arn:aws:iam::123456789012:role/admin#mfa"}}, "an MFA code is required"},
+		{"SAML", roleOptions{SAMLAssertionFile: "assertion.txt"}, "a new SAML assertion is required"},
+		{"IAM Identity Center", roleOptions{SSOStartURL: "https://example.awsapps.com/start", SSORegion: "us-east-1", SSOAccountID: "123456789012", SSORoleName: "Admin"}, "IAM Identity Center sign-in is required"},
+	}
+
+	for _, tc := range testCases {
+		if reason := refreshBlocker(&tc.opts); reason != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.expected, reason)
+		}
+	}
+}
+
+// Test that a cached MFA session lets the daemon refresh an MFA profile
+func TestRefreshBlockerMFASession(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	opts := &roleOptions{SourceProfile: "user", RoleArns: []string{"arn:aws:iam::123456789012:role/admin"}, MFAPrompt: true, RefreshWindow: time.Minute}
+	saveCachedCredentials(mfaSessionCacheKey("user"), &Credentials{AccessKeyId: "ASIASESSION", Expiration: time.Now().Add(time.Hour)})
+
+	if reason := refreshBlocker(opts); reason != "" {
+		t.Errorf("Expected the MFA session to allow a refresh, got %q", reason)
+	}
+}
+
+// Test that the daemon rewrites expiring profiles and reports the ones that need a human once
+func TestRefreshDaemon(t *testing.T) {
+	newMockSTSServer(t, map[string]string{"AssumeRole": mockAssumeRoleResponse})
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	dir := t.TempDir()
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+
+	soon := &Credentials{AccessKeyId: "ASIAOLD", SecretAccessKey: "old", SessionToken: "old", Expiration: time.Now().Add(5 * time.Minute)}
+	later := &Credentials{AccessKeyId: "ASIALATER", SecretAccessKey: "later", SessionToken: "later", Expiration: time.Now().Add(time.Hour)}
+	profiles := []struct {
+		name        string
+		opts        *roleOptions
+		credentials *Credentials
+	}{
+		{"expiring", &roleOptions{RoleArns: []string{"arn:aws:iam::123456789012:role/reader"}, Region: "us-west-2", Duration: 3600}, soon},
+		{"needs-mfa", &roleOptions{RoleArns: []string{"arn:aws:iam::123456789012:role/admin"}, MFAToken: "123456", Duration: 3600}, soon},
+		{"still-valid", &roleOptions{RoleArns: []string{"arn:aws:iam::123456789012:role/reader"}, Duration: 3600}, later},
+	}
+	for _, p := range profiles {
+		write, err := configureAWSProfile(p.name, p.credentials, "", "us-west-2", io.Discard)
+		if err != nil {
+			t.Fatalf("configureAWSProfile failed: %v", err)
+		}
+		if err := recordManagedProfile(p.name, p.opts, p.credentials, write); err != nil {
+			t.Fatalf("recordManagedProfile failed: %v", err)
+		}
+	}
+
+	var log bytes.Buffer
+	d := &refreshDaemon{opts: &daemonOptions{RefreshBefore: 10 * time.Minute}, out: &log, notified: make(map[string]time.Time)}
+
+	// Everything the daemon has to say goes to its log, nothing to stdout
+	oldStdout := os.Stdout
+	stdoutR, stdoutW, _ := os.Pipe()
+	os.Stdout = stdoutW
+	d.refreshAll()
+	stdoutW.Close()
+	os.Stdout = oldStdout
+	var stdout bytes.Buffer
+	io.Copy(&stdout, stdoutR)
+	if stdout.Len() > 0 {
+		t.Errorf("Expected nothing on stdout, got:\n%s", stdout.String())
+	}
+
+	settings := func(profile string) map[string]string {
+		values, _, err := loadProfile(profile)
+		if err != nil {
+			t.Fatalf("loadProfile failed: %v", err)
+		}
+		return values
+	}
+	if key := settings("expiring")["aws_access_key_id"]; key != "ASIAMOCK123456789012" {
+		t.Errorf("Expected the expiring profile to be refreshed, got key %s", key)
+	}
+	if key := settings("needs-mfa")["aws_access_key_id"]; key != "ASIAOLD" {
+		t.Errorf("Expected the MFA profile to be left alone, got key %s", key)
+	}
+	if key := settings("still-valid")["aws_access_key_id"]; key != "ASIALATER" {
+		t.Errorf("Expected the valid profile to be left alone, got key %s", key)
+	}
+
+	recorded, _ := loadManagedProfiles()
+	if recorded[0].Profile != "expiring" || recorded[0].Expiration.Year() != 2023 {
+		t.Errorf("Expected the new expiration to be recorded, got %+v", recorded[0])
+	}
+	if !strings.Contains(log.String(), "Refreshed profile expiring") || !strings.Contains(log.String(), "needs-mfa expires at") {
+		t.Errorf("Unexpected daemon log:\n%s", log.String())
+	}
+
+	// The MFA profile is only reported once per expiration
+	log.Reset()
+	d.refreshAll()
+	if strings.Contains(log.String(), "needs-mfa") {
+		t.Errorf("Expected the MFA profile not to be reported again, got:\n%s", log.String())
+	}
+}
+
+// Test that the daemon leaves a profile alone that was regenerated while it was refreshing
+func TestRefreshManagedProfileChanged(t *testing.T) {
+	newMockSTSServer(t, map[string]string{"AssumeRole": mockAssumeRoleResponse})
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	dir := t.TempDir()
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+
+	opts := &roleOptions{RoleArns: []string{"arn:aws:iam::123456789012:role/reader"}, Duration: 3600}
+	for _, key := range []string{"ASIAOLD", "ASIANEW"} {
+		credentials := &Credentials{AccessKeyId: key, SecretAccessKey: "secret", SessionToken: "token", Expiration: time.Now().Add(time.Hour)}
+		write, err := configureAWSProfile("work", credentials, "", "us-west-2", io.Discard)
+		if err != nil {
+			t.Fatalf("configureAWSProfile failed: %v", err)
+		}
+		if err := recordManagedProfile("work", opts, credentials, write); err != nil {
+			t.Fatalf("recordManagedProfile failed: %v", err)
+		}
+	}
+
+	// The daemon still holds the record from before the profile was regenerated
+	stale := managedProfile{Profile: "work", Options: *opts, AccessKeyId: "ASIAOLD"}
+	if _, err := refreshManagedProfile(stale); err != errManagedProfileChanged {
+		t.Errorf("Expected errManagedProfileChanged, got %v", err)
+	}
+	if values, _, _ := loadProfile("work"); values["aws_access_key_id"] != "ASIANEW" {
+		t.Errorf("Expected the regenerated credentials to be kept, got %s", values["aws_access_key_id"])
+	}
+}
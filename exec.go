@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// exitCodeError makes awsomecreds exit with the exit code of a child process
+type exitCodeError struct {
+	code int
+}
+
+func (e *exitCodeError) Error() string {
+	return fmt.Sprintf("command exited with code %d", e.code)
+}
+
+// runWithCredentials assumes the role described by opts and runs command
+// with the credentials in its environment only. With useServer the command
+// gets the address of a local credentials server instead, which keeps
+// refreshing them. Signals are forwarded to the command, and its exit code is
+// returned as an exitCodeError.
+func runWithCredentials(opts *roleOptions, command []string, useServer bool) error {
+	provider := newCredentialProvider(opts, os.Stderr)
+	credentials, err := provider.Get()
+	if err != nil {
+		return err
+	}
+
+	cmd := execCommand(command[0], command[1:]...)
+	if useServer {
+		server, err := startCredentialServer(provider, defaultServeAddr)
+		if err != nil {
+			return err
+		}
+		defer server.Close()
+		cmd.Env = serverEnv(cmd.Environ(), server, credentialRegion(opts))
+	} else {
+		cmd.Env = execEnv(cmd.Environ(), credentials, credentialRegion(opts))
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// Catch signals before starting so none is lost; the command gets them instead
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	defer signal.Stop(signals)
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to run %s: %w", command[0], err)
+	}
+	go func() {
+		for sig := range signals {
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	err = cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code := exitErr.ExitCode()
+		if code < 0 {
+			// Killed by a signal, report it the way shells do
+			code = 128
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+				code += int(status.Signal())
+			}
+		}
+		return &exitCodeError{code: code}
+	}
+	return err
+}
+
+// execEnv returns env for a child process with the AWS profile and credential
+// variables replaced by credentials, region and the expiration time
+func execEnv(env []string, credentials *Credentials, region string) []string {
+	filtered := make([]string, 0, len(env))
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, "AWS_CREDENTIAL_EXPIRATION=") {
+			filtered = append(filtered, kv)
+		}
+	}
+	return append(credentialEnv(filtered, credentials, region),
+		"AWS_CREDENTIAL_EXPIRATION="+credentials.Expiration.UTC().Format(time.RFC3339))
+}
+
+// serverEnv returns env for a child process that gets its credentials from
+// server, without any static credentials it could pick up first
+func serverEnv(env []string, server *credentialServer, region string) []string {
+	filtered := make([]string, 0, len(env))
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, "AWS_CREDENTIAL_EXPIRATION=") &&
+			!strings.HasPrefix(kv, "AWS_CONTAINER_CREDENTIALS_RELATIVE_URI=") {
+			filtered = append(filtered, kv)
+		}
+	}
+	result := append(withoutCredentialEnv(filtered, region), server.Env()...)
+	if region != "" {
+		result = append(result, "AWS_REGION="+region, "AWS_DEFAULT_REGION="+region)
+	}
+	return result
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that exec runs the command with the credentials and passes on its exit code
+func TestRunWithCredentials(t *testing.T) {
+	newMockSTSServer(t, map[string]string{"AssumeRole": mockAssumeRoleResponse})
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	execCommand = mockExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	opts := &roleOptions{
+		RoleArns: []string{"arn:aws:iam::123456789012:role/TestRole"},
+		Region:   "eu-west-1",
+		Duration: 3600,
+		NoCache:  true,
+	}
+
+	// Capture the output of the command and discard the status messages
+	oldStdout, oldStderr := os.Stdout, os.Stderr
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	devNull, _ := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	os.Stderr = devNull
+	err := runWithCredentials(opts, []string{"show-aws-env"}, false)
+	w.Close()
+	devNull.Close()
+	os.Stdout, os.Stderr = oldStdout, oldStderr
+	output, _ := io.ReadAll(r)
+
+	var exitErr *exitCodeError
+	if !errors.As(err, &exitErr) || exitErr.code != 3 {
+		t.Fatalf("Expected exit code 3, got %v", err)
+	}
+	expected := "AWS_PROFILE=\nAWS_ACCESS_KEY_ID=ASIAMOCK123456789012\nAWS_SESSION_TOKEN=mockSessionToken\nAWS_REGION=eu-west-1\nAWS_CREDENTIAL_EXPIRATION=2023-12-31T23:59:59Z\n"
+	if string(output) != expected {
+		t.Errorf("Unexpected command environment:\n%s\nexpected:\n%s", output, expected)
+	}
+}
+
+// Test that conflicting variables are replaced in the command environment
+func TestExecEnv(t *testing.T) {
+	credentials := &Credentials{
+		AccessKeyId:     "ASIANEW",
+		SecretAccessKey: "secret",
+		SessionToken:    "token",
+		Expiration:      time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	env := execEnv([]string{
+		"PATH=/usr/bin",
+		"AWS_PROFILE=admin",
+		"AWS_ACCESS_KEY_ID=AKIAOLD",
+		"AWS_CREDENTIAL_EXPIRATION=2020-01-01T00:00:00Z",
+	}, credentials, "us-west-2")
+
+	expected := []string{
+		"PATH=/usr/bin",
+		"AWS_ACCESS_KEY_ID=ASIANEW",
+		"AWS_SECRET_ACCESS_KEY=secret",
+		"AWS_SESSION_TOKEN=token",
+		"AWS_REGION=us-west-2",
+		"AWS_DEFAULT_REGION=us-west-2",
+		"AWS_CREDENTIAL_EXPIRATION=2030-01-01T00:00:00Z",
+	}
+	if strings.Join(env, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected environment:\n%s\nexpected:\n%s", strings.Join(env, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+// Test that a command using the credentials server gets no static credentials
+func TestServerEnv(t *testing.T) {
+	server := &credentialServer{token: "secret-token"}
+	server.listener, _ = net.Listen("tcp", "127.0.0.1:0")
+	defer server.listener.Close()
+
+	env := serverEnv([]string{
+		"PATH=/usr/bin",
+		"AWS_PROFILE=admin",
+		"AWS_ACCESS_KEY_ID=AKIAOLD",
+		"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI=/v2/credentials",
+	}, server, "us-west-2")
+
+	expected := []string{
+		"PATH=/usr/bin",
+		"AWS_CONTAINER_CREDENTIALS_FULL_URI=" + server.URL(),
+		"AWS_CONTAINER_AUTHORIZATION_TOKEN=secret-token",
+		"AWS_REGION=us-west-2",
+		"AWS_DEFAULT_REGION=us-west-2",
+	}
+	if strings.Join(env, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected environment:\n%s\nexpected:\n%s", strings.Join(env, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+// Test when the first argument of exec is taken for a target
+func TestExecTarget(t *testing.T) {
+	roleArnGiven := func(flag string) bool { return flag == "role-arn" }
+	noFlags := func(string) bool { return false }
+
+	testCases := []struct {
+		name          string
+		args          []string
+		argsLenAtDash int
+		changed       func(string) bool
+		expected      string
+	}{
+		{name: "Target", args: []string{"prod", "--", "aws", "s3", "ls"}, argsLenAtDash: -1, changed: noFlags, expected: "prod"},
+		{name: "Command after dash", args: []string{"echo", "--", "hi"}, argsLenAtDash: 0, changed: noFlags, expected: ""},
+		{name: "Role ARN with command containing dash", args: []string{"echo", "--", "hi"}, argsLenAtDash: -1, changed: roleArnGiven, expected: ""},
+		{name: "SSO with command containing dash", args: []string{"git", "--", "log"}, argsLenAtDash: -1, changed: func(flag string) bool { return flag == "sso-start-url" }, expected: ""},
+		{name: "No dash", args: []string{"aws", "s3", "ls"}, argsLenAtDash: -1, changed: noFlags, expected: ""},
+		{name: "Nothing after dash", args: []string{"prod", "--"}, argsLenAtDash: -1, changed: noFlags, expected: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if target := execTarget(tc.args, tc.argsLenAtDash, tc.changed); target != tc.expected {
+				t.Errorf("Expected target %q, got %q", tc.expected, target)
+			}
+		})
+	}
+}
@@ -2,7 +2,12 @@ module github.com/coreyculler/awsomecreds
 
 go 1.21
 
-require github.com/spf13/cobra v1.8.0
+require (
+	github.com/spf13/cobra v1.8.0
+	golang.org/x/sys v0.20.0
+	golang.org/x/term v0.20.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
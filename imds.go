@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// imdsCredentialsPath lists the instance role, and serves its credentials below it
+	imdsCredentialsPath = "/latest/meta-data/iam/security-credentials/"
+	// imdsMaxTokenTTL is the longest session token lifetime IMDSv2 grants, in seconds
+	imdsMaxTokenTTL = 21600
+)
+
+// imdsCredentials is the credentials document of the instance metadata service
+type imdsCredentials struct {
+	Code            string `json:"Code"`
+	LastUpdated     string `json:"LastUpdated"`
+	Type            string `json:"Type"`
+	AccessKeyId     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+	Expiration      string `json:"Expiration"`
+}
+
+// imdsServer emulates the parts of the EC2 instance metadata service (IMDSv2)
+// that SDKs use to find instance role credentials, serving them from a provider
+type imdsServer struct {
+	provider *credentialProvider
+	roleName string
+	region   string
+	listener net.Listener
+	server   *http.Server
+
+	mu     sync.Mutex
+	tokens map[string]time.Time
+}
+
+// newIMDSServer returns an unstarted metadata server for provider
+func newIMDSServer(provider *credentialProvider, roleName, region string) *imdsServer {
+	return &imdsServer{
+		provider: provider,
+		roleName: roleName,
+		region:   region,
+		tokens:   make(map[string]time.Time),
+	}
+}
+
+// startIMDSServer starts serving provider as instance role roleName on addr.
+// Unlike the ECS endpoint, addr may be any address, e.g. a Docker bridge.
+func startIMDSServer(provider *credentialProvider, roleName, region, addr string) (*imdsServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	s := newIMDSServer(provider, roleName, region)
+	s.listener = listener
+	s.server = &http.Server{Handler: s, ReadHeaderTimeout: 10 * time.Second}
+	go s.server.Serve(listener)
+	return s, nil
+}
+
+// imdsRoleName returns the instance role name to present for opts: the name
+// of the last role assumed
+func imdsRoleName(opts *roleOptions) string {
+	if len(opts.RoleArns) > 0 {
+		arn, _, _ := strings.Cut(opts.RoleArns[len(opts.RoleArns)-1], "#")
+		return arn[strings.LastIndex(arn, "/")+1:]
+	}
+	if opts.SSORoleName != "" {
+		return opts.SSORoleName
+	}
+	return "awsomecreds"
+}
+
+// URL returns the value for AWS_EC2_METADATA_SERVICE_ENDPOINT
+func (s *imdsServer) URL() string {
+	return "http://" + s.listener.Addr().String() + "/"
+}
+
+// Env returns the variables that point AWS SDKs at the server
+func (s *imdsServer) Env() []string {
+	return []string{"AWS_EC2_METADATA_SERVICE_ENDPOINT=" + s.URL()}
+}
+
+// Close stops the server
+func (s *imdsServer) Close() error {
+	return s.server.Close()
+}
+
+// ServeHTTP answers token and metadata requests
+func (s *imdsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/latest/api/token" {
+		s.serveToken(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Only IMDSv2 is emulated, so every request needs a session token
+	if !s.validToken(r.Header.Get("X-aws-ec2-metadata-token")) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.URL.Path == imdsCredentialsPath:
+		fmt.Fprint(w, s.roleName)
+	case r.URL.Path == imdsCredentialsPath+s.roleName:
+		s.writeCredentials(w)
+	case r.URL.Path == "/latest/meta-data/placement/region" && s.region != "":
+		fmt.Fprint(w, s.region)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveToken issues a session token for PUT /latest/api/token
+func (s *imdsServer) serveToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Like EC2, refuse tokens to requests that went through a proxy
+	if r.Header.Get("X-Forwarded-For") != "" {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	ttl, err := strconv.Atoi(r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds"))
+	if err != nil || ttl < 1 || ttl > imdsMaxTokenTTL {
+		http.Error(w, "invalid token TTL", http.StatusBadRequest)
+		return
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	for t, expiry := range s.tokens {
+		if now.After(expiry) {
+			delete(s.tokens, t)
+		}
+	}
+	s.tokens[token] = now.Add(time.Duration(ttl) * time.Second)
+	s.mu.Unlock()
+
+	w.Header().Set("X-aws-ec2-metadata-token-ttl-seconds", strconv.Itoa(ttl))
+	fmt.Fprint(w, token)
+}
+
+// validToken reports whether token was issued by the server and has not expired
+func (s *imdsServer) validToken(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expiry, ok := s.tokens[token]
+	return ok && time.Now().Before(expiry)
+}
+
+// writeCredentials writes the credentials document of the instance role
+func (s *imdsServer) writeCredentials(w http.ResponseWriter) {
+	credentials, err := s.provider.Get()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error refreshing credentials: %v\n", err)
+		http.Error(w, "failed to get credentials", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(imdsCredentials{
+		Code:            "Success",
+		LastUpdated:     time.Now().UTC().Format(time.RFC3339),
+		Type:            "AWS-HMAC",
+		AccessKeyId:     credentials.AccessKeyId,
+		SecretAccessKey: credentials.SecretAccessKey,
+		Token:           credentials.SessionToken,
+		Expiration:      credentials.Expiration.UTC().Format(time.RFC3339),
+	})
+}
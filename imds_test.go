@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// imdsRequest sends a request to the emulated metadata service and returns the status and body
+func imdsRequest(t *testing.T, method, url string, headers map[string]string) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+// Test the IMDSv2 token handshake and the instance role credentials
+func TestIMDSServer(t *testing.T) {
+	provider := newCredentialProvider(&roleOptions{}, &bytes.Buffer{})
+	provider.current = &Credentials{
+		AccessKeyId:     "ASIASERVED",
+		SecretAccessKey: "secret",
+		SessionToken:    "token",
+		Expiration:      time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	imds := newIMDSServer(provider, "my-role", "eu-west-1")
+	server := httptest.NewServer(imds)
+	defer server.Close()
+
+	// Requests without a session token are refused, IMDSv1 is not emulated
+	if status, _ := imdsRequest(t, http.MethodGet, server.URL+imdsCredentialsPath, nil); status != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without a token, got %d", status)
+	}
+
+	// Token requests need a valid TTL and must not be proxied
+	tokenTestCases := []struct {
+		name           string
+		headers        map[string]string
+		expectedStatus int
+	}{
+		{"valid TTL", map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "21600"}, http.StatusOK},
+		{"missing TTL", nil, http.StatusBadRequest},
+		{"TTL too long", map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "21601"}, http.StatusBadRequest},
+		{"proxied", map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "60", "X-Forwarded-For": "10.0.0.1"}, http.StatusForbidden},
+	}
+	var token string
+	for _, tc := range tokenTestCases {
+		status, body := imdsRequest(t, http.MethodPut, server.URL+"/latest/api/token", tc.headers)
+		if status != tc.expectedStatus {
+			t.Errorf("%s: expected status %d, got %d", tc.name, tc.expectedStatus, status)
+		}
+		if status == http.StatusOK {
+			token = body
+		}
+	}
+
+	headers := map[string]string{"X-aws-ec2-metadata-token": token}
+	if status, body := imdsRequest(t, http.MethodGet, server.URL+imdsCredentialsPath, headers); status != http.StatusOK || body != "my-role" {
+		t.Errorf("Expected role listing 'my-role', got %d '%s'", status, body)
+	}
+	if status, body := imdsRequest(t, http.MethodGet, server.URL+"/latest/meta-data/placement/region", headers); status != http.StatusOK || body != "eu-west-1" {
+		t.Errorf("Expected region 'eu-west-1', got %d '%s'", status, body)
+	}
+	if status, _ := imdsRequest(t, http.MethodGet, server.URL+imdsCredentialsPath+"other-role", headers); status != http.StatusNotFound {
+		t.Errorf("Expected status 404 for another role, got %d", status)
+	}
+
+	status, body := imdsRequest(t, http.MethodGet, server.URL+imdsCredentialsPath+"my-role", headers)
+	if status != http.StatusOK {
+		t.Fatalf("Expected status 200 for the credentials, got %d", status)
+	}
+	var document imdsCredentials
+	if err := json.Unmarshal([]byte(body), &document); err != nil {
+		t.Fatalf("Invalid credentials document: %v", err)
+	}
+	if document.Code != "Success" || document.Type != "AWS-HMAC" || document.AccessKeyId != "ASIASERVED" ||
+		document.Token != "token" || document.Expiration != "2030-01-01T00:00:00Z" {
+		t.Errorf("Unexpected credentials document %+v", document)
+	}
+
+	// Expired tokens are refused
+	imds.tokens[token] = time.Now().Add(-time.Second)
+	if status, _ := imdsRequest(t, http.MethodGet, server.URL+imdsCredentialsPath, headers); status != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 with an expired token, got %d", status)
+	}
+}
+
+// Test the instance role name presented for different sources
+func TestIMDSRoleName(t *testing.T) {
+	testCases := []struct {
+		opts     roleOptions
+		expected string
+	}{
+		{roleOptions{RoleArns: []string{"arn:aws:iam::111111111111:role/hub", "arn:aws:iam::222222222222:role/path/workload#mfa"}}, "workload"},
+		{roleOptions{SSORoleName: "AdministratorAccess"}, "AdministratorAccess"},
+		{roleOptions{}, "awsomecreds"},
+	}
+	for _, tc := range testCases {
+		if name := imdsRoleName(&tc.opts); name != tc.expected {
+			t.Errorf("imdsRoleName(%+v) = %s, expected %s", tc.opts, name, tc.expected)
+		}
+	}
+}
+
+// Test that the metadata endpoint variable points at the listener
+func TestIMDSServerEnv(t *testing.T) {
+	server, err := startIMDSServer(newCredentialProvider(&roleOptions{}, &bytes.Buffer{}), "my-role", "", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("startIMDSServer failed: %v", err)
+	}
+	defer server.Close()
+
+	env := server.Env()
+	if len(env) != 1 || !strings.HasPrefix(env[0], "AWS_EC2_METADATA_SERVICE_ENDPOINT=http://127.0.0.1:") {
+		t.Errorf("Unexpected environment %v", env)
+	}
+}
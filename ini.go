@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// iniFile is an AWS style INI document that preserves comments, blank lines
+// and ordering so it can be written back without disturbing other sections
+type iniFile struct {
+	// preamble holds the lines before the first section header
+	preamble []iniLine
+	sections []*iniSection
+	// noFinalNewline records that the last line had no trailing newline
+	noFinalNewline bool
+}
+
+// iniSection is a named section and the lines that follow its header
+type iniSection struct {
+	name   string
+	header string
+	lines  []iniLine
+}
+
+// iniLine is a single line of an INI file. key is empty for comments,
+// blank lines and indented continuation lines.
+type iniLine struct {
+	raw   string
+	key   string
+	value string
+}
+
+// parseINIFile parses an AWS style INI document
+func parseINIFile(r io.Reader) (*iniFile, error) {
+	file := &iniFile{}
+	var current *iniSection
+
+	reader := bufio.NewReader(r)
+	lineNumber := 0
+	for {
+		raw, err := reader.ReadString('\n')
+		if raw == "" && err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		lineNumber++
+		if strings.HasSuffix(raw, "\n") {
+			raw = raw[:len(raw)-1]
+		} else {
+			file.noFinalNewline = true
+		}
+		line := iniLine{raw: raw}
+		trimmed := strings.TrimSpace(raw)
+
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";"):
+			// Comments and blank lines are kept as-is
+		case strings.HasPrefix(trimmed, "["):
+			// Like the aws CLI, allow a comment after the header
+			end := strings.Index(trimmed, "]")
+			rest := strings.TrimSpace(trimmed[end+1:])
+			if end < 0 || (rest != "" && !strings.HasPrefix(rest, "#") && !strings.HasPrefix(rest, ";")) {
+				return nil, fmt.Errorf("line %d: malformed section header %q", lineNumber, trimmed)
+			}
+			current = &iniSection{name: strings.TrimSpace(trimmed[1:end]), header: raw}
+			file.sections = append(file.sections, current)
+			continue
+		case raw[0] == ' ' || raw[0] == '\t':
+			// Indented lines are nested values (e.g. s3 settings) of the previous key
+		default:
+			if current == nil {
+				return nil, fmt.Errorf("line %d: key outside of a section", lineNumber)
+			}
+			// Lines without '=' are kept verbatim but never treated as keys
+			if key, value, ok := strings.Cut(trimmed, "="); ok {
+				line.key = strings.TrimSpace(key)
+				line.value = strings.TrimSpace(value)
+			}
+		}
+
+		if current == nil {
+			file.preamble = append(file.preamble, line)
+		} else {
+			current.lines = append(current.lines, line)
+		}
+	}
+	return file, nil
+}
+
+// loadINIFile parses the INI file at path, returning an empty document if it does not exist
+func loadINIFile(path string) (*iniFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &iniFile{}, nil
+		}
+		return nil, err
+	}
+
+	file, err := parseINIFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// save atomically writes the document to path with 0600 permissions
+func (f *iniFile) save(path string) error {
+	return writeFileAtomic(path, f.bytes(), 0600)
+}
+
+// bytes renders the document, reproducing unmodified lines exactly
+func (f *iniFile) bytes() []byte {
+	var buf bytes.Buffer
+	for _, line := range f.preamble {
+		buf.WriteString(line.raw + "\n")
+	}
+	for _, section := range f.sections {
+		buf.WriteString(section.header + "\n")
+		for _, line := range section.lines {
+			buf.WriteString(line.raw + "\n")
+		}
+	}
+	if f.noFinalNewline {
+		return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	}
+	return buf.Bytes()
+}
+
+// hasSection reports whether a section called name exists
+func (f *iniFile) hasSection(name string) bool {
+	for _, section := range f.sections {
+		if section.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// values returns the keys of section name. Repeated sections are merged
+// with later values winning, like the AWS CLI.
+func (f *iniFile) values(name string) map[string]string {
+	values := make(map[string]string)
+	for _, section := range f.sections {
+		if section.name != name {
+			continue
+		}
+		for _, line := range section.lines {
+			if line.key != "" {
+				values[line.key] = line.value
+			}
+		}
+	}
+	return values
+}
+
+// get returns the value of key in section name
+func (f *iniFile) get(name, key string) (string, bool) {
+	value, ok := f.values(name)[key]
+	return value, ok
+}
+
+// line returns the raw line of key in section name, the one get reads
+func (f *iniFile) line(name, key string) (string, bool) {
+	for i := len(f.sections) - 1; i >= 0; i-- {
+		if f.sections[i].name != name {
+			continue
+		}
+		for j := len(f.sections[i].lines) - 1; j >= 0; j-- {
+			if f.sections[i].lines[j].key == key {
+				return f.sections[i].lines[j].raw, true
+			}
+		}
+	}
+	return "", false
+}
+
+// replaceLine replaces the line of key in section name with raw, a line
+// returned by line, and reports whether the key was there
+func (f *iniFile) replaceLine(name, key, raw string) bool {
+	for i := len(f.sections) - 1; i >= 0; i-- {
+		section := f.sections[i]
+		if section.name != name {
+			continue
+		}
+		for j := len(section.lines) - 1; j >= 0; j-- {
+			if section.lines[j].key == key {
+				_, value, _ := strings.Cut(strings.TrimSpace(raw), "=")
+				section.lines[j] = iniLine{raw: raw, key: key, value: strings.TrimSpace(value)}
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// set updates key in section name, adding the key or section if needed
+func (f *iniFile) set(name, key, value string) {
+	raw := key + " = " + value
+
+	// Update the last occurrence of the key so it keeps winning
+	for i := len(f.sections) - 1; i >= 0; i-- {
+		section := f.sections[i]
+		if section.name != name {
+			continue
+		}
+		for j := len(section.lines) - 1; j >= 0; j-- {
+			if section.lines[j].key == key {
+				section.lines[j] = iniLine{raw: raw, key: key, value: value}
+				return
+			}
+		}
+	}
+
+	// Append to the existing section after its last key or nested value
+	for _, section := range f.sections {
+		if section.name != name {
+			continue
+		}
+		insert := section.valuesEnd()
+		lines := append([]iniLine{}, section.lines[:insert]...)
+		lines = append(lines, iniLine{raw: raw, key: key, value: value})
+		section.lines = append(lines, section.lines[insert:]...)
+		return
+	}
+
+	// Create a new section at the end, separated by a blank line
+	if f.needsSeparator() {
+		if len(f.sections) > 0 {
+			last := f.sections[len(f.sections)-1]
+			last.lines = append(last.lines, iniLine{})
+		} else {
+			f.preamble = append(f.preamble, iniLine{})
+		}
+	}
+	f.sections = append(f.sections, &iniSection{
+		name:   name,
+		header: "[" + name + "]",
+		lines:  []iniLine{{raw: raw, key: key, value: value}},
+	})
+}
+
+// needsSeparator reports whether set adds a blank line before a new section
+func (f *iniFile) needsSeparator() bool {
+	if len(f.sections) > 0 {
+		lines := f.sections[len(f.sections)-1].lines
+		return len(lines) == 0 || strings.TrimSpace(lines[len(lines)-1].raw) != ""
+	}
+	n := len(f.preamble)
+	return n > 0 && strings.TrimSpace(f.preamble[n-1].raw) != ""
+}
+
+// removeSection removes every section called name, from its header to its
+// last key or nested value, and reports whether there was one. Comments and
+// blank lines after that belong to whatever follows and are kept. With
+// separated, the blank line set added before the section is removed too.
+func (f *iniFile) removeSection(name string, separated bool) bool {
+	var kept []*iniSection
+	removed := false
+	for _, section := range f.sections {
+		if section.name != name {
+			kept = append(kept, section)
+			continue
+		}
+
+		before := &f.preamble
+		if len(kept) > 0 {
+			before = &kept[len(kept)-1].lines
+		}
+		if n := len(*before); separated && !removed && n > 0 && strings.TrimSpace((*before)[n-1].raw) == "" {
+			*before = (*before)[:n-1]
+		}
+		*before = append(*before, section.lines[section.valuesEnd():]...)
+		removed = true
+	}
+	f.sections = kept
+	return removed
+}
+
+// removeKeys removes keys from every section called name, keeping all other lines
+func (f *iniFile) removeKeys(name string, keys ...string) {
+	for _, section := range f.sections {
+		if section.name != name {
+			continue
+		}
+		lines := section.lines[:0]
+		for _, line := range section.lines {
+			if !containsString(keys, line.key) {
+				lines = append(lines, line)
+			}
+		}
+		section.lines = lines
+	}
+}
+
+// valuesEnd returns the index after the last key or nested value of the section
+func (s *iniSection) valuesEnd() int {
+	end := 0
+	for i, line := range s.lines {
+		if line.key != "" || (line.raw != "" && (line.raw[0] == ' ' || line.raw[0] == '\t')) {
+			end = i + 1
+		}
+	}
+	return end
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory,
+// syncs it and renames it over path so readers never see a partial file.
+// A symlink at path is followed and its target replaced instead.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	// Dotfile managers often link ~/.aws files, and renaming over the link would detach it
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // No-op once the rename has succeeded
+
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set permissions on %s: %w", tmpName, err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", tmpName, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync %s: %w", tmpName, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", tmpName, err)
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+
+	// Sync the directory so the rename itself is durable
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testINIDocument = `# Shared credentials
+; managed by hand
+
+[default]
+aws_access_key_id = AKIDDEFAULT
+aws_secret_access_key=defaultsecret
+# trailing comment
+
+[profile dev]
+region = us-west-2
+s3 =
+    max_concurrent_requests = 10
+[profile prod]
+region=eu-west-1`
+
+// Test that an unmodified document is reproduced byte for byte
+func TestINIRoundTrip(t *testing.T) {
+	file, err := parseINIFile(strings.NewReader(testINIDocument))
+	if err != nil {
+		t.Fatalf("parseINIFile failed: %v", err)
+	}
+	if got := string(file.bytes()); got != testINIDocument {
+		t.Errorf("Round trip changed the document:\n%q\nexpected:\n%q", got, testINIDocument)
+	}
+
+	if value, ok := file.get("default", "aws_access_key_id"); !ok || value != "AKIDDEFAULT" {
+		t.Errorf("Expected aws_access_key_id 'AKIDDEFAULT', got '%s'", value)
+	}
+	if value, _ := file.get("profile prod", "region"); value != "eu-west-1" {
+		t.Errorf("Expected region 'eu-west-1', got '%s'", value)
+	}
+	if _, ok := file.get("profile dev", "max_concurrent_requests"); ok {
+		t.Errorf("Nested values should not be treated as top level keys")
+	}
+}
+
+// Test set updates keys in place and appends new keys and sections
+func TestINISet(t *testing.T) {
+	file, err := parseINIFile(strings.NewReader(testINIDocument))
+	if err != nil {
+		t.Fatalf("parseINIFile failed: %v", err)
+	}
+
+	file.set("default", "aws_secret_access_key", "newsecret")
+	file.set("profile dev", "output", "json")
+	file.set("profile new", "region", "ap-south-1")
+
+	expected := `# Shared credentials
+; managed by hand
+
+[default]
+aws_access_key_id = AKIDDEFAULT
+aws_secret_access_key = newsecret
+# trailing comment
+
+[profile dev]
+region = us-west-2
+s3 =
+    max_concurrent_requests = 10
+output = json
+[profile prod]
+region=eu-west-1
+
+[profile new]
+region = ap-south-1`
+	if got := string(file.bytes()); got != expected {
+		t.Errorf("Unexpected document after set:\n%s\nexpected:\n%s", got, expected)
+	}
+}
+
+// Test removing sections and keys keeps every other line as it was
+func TestINIRemove(t *testing.T) {
+	file, err := parseINIFile(strings.NewReader(testINIDocument))
+	if err != nil {
+		t.Fatalf("parseINIFile failed: %v", err)
+	}
+
+	if !file.removeSection("profile dev", false) || file.removeSection("profile missing", false) {
+		t.Errorf("Expected removeSection to report which sections existed")
+	}
+	file.removeKeys("default", "aws_secret_access_key")
+
+	expected := `# Shared credentials
+; managed by hand
+
+[default]
+aws_access_key_id = AKIDDEFAULT
+# trailing comment
+
+[profile prod]
+region=eu-west-1`
+	if got := string(file.bytes()); got != expected {
+		t.Errorf("Unexpected document after removing:\n%s\nexpected:\n%s", got, expected)
+	}
+}
+
+// Test a removed section leaves the comments after its keys to the next
+// section and takes the blank line added before it along
+func TestINIRemoveSectionSpan(t *testing.T) {
+	document := "[a]\nk = v\n\n[temp]\nk = v\n# about b\n[b]\nk = v\n"
+	testCases := []struct {
+		separated bool
+		expected  string
+	}{
+		{separated: true, expected: "[a]\nk = v\n# about b\n[b]\nk = v\n"},
+		{separated: false, expected: "[a]\nk = v\n\n# about b\n[b]\nk = v\n"},
+	}
+
+	for _, tc := range testCases {
+		file, err := parseINIFile(strings.NewReader(document))
+		if err != nil {
+			t.Fatalf("parseINIFile failed: %v", err)
+		}
+		file.removeSection("temp", tc.separated)
+		if got := string(file.bytes()); got != tc.expected {
+			t.Errorf("Unexpected document with separated %v:\n%q\nexpected:\n%q", tc.separated, got, tc.expected)
+		}
+	}
+}
+
+// Test comments after a section header are accepted and kept on write
+func TestINIHeaderComment(t *testing.T) {
+	document := "[default]   # personal account\nregion = us-east-1\n[profile work] ; office\nregion = eu-west-1\n"
+	file, err := parseINIFile(strings.NewReader(document))
+	if err != nil {
+		t.Fatalf("parseINIFile failed: %v", err)
+	}
+	if value, _ := file.get("default", "region"); value != "us-east-1" {
+		t.Errorf("Expected region 'us-east-1', got '%s'", value)
+	}
+	if value, _ := file.get("profile work", "region"); value != "eu-west-1" {
+		t.Errorf("Expected region 'eu-west-1', got '%s'", value)
+	}
+
+	file.set("default", "output", "json")
+	expected := "[default]   # personal account\nregion = us-east-1\noutput = json\n[profile work] ; office\nregion = eu-west-1\n"
+	if got := string(file.bytes()); got != expected {
+		t.Errorf("Unexpected document:\n%q\nexpected:\n%q", got, expected)
+	}
+
+	if _, err := parseINIFile(strings.NewReader("[default] extra\n")); err == nil {
+		t.Errorf("Expected an error for text after a header that isn't a comment")
+	}
+}
+
+// Test parse errors are reported with line numbers
+func TestINIParseErrors(t *testing.T) {
+	for _, document := range []string{"[broken\nkey = value\n", "key = value\n[default]\n"} {
+		if _, err := parseINIFile(strings.NewReader(document)); err == nil || !strings.Contains(err.Error(), "line 1") {
+			t.Errorf("Expected a line 1 error for %q, got %v", document, err)
+		}
+	}
+}
+
+// Test writeFileAtomic creates the file with the requested permissions
+func TestWriteFileAtomic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "credentials")
+	if err := writeFileAtomic(path, []byte("[default]\n"), 0600); err != nil {
+		t.Fatalf("writeFileAtomic failed: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %v", path, err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected permissions 0600, got %o", info.Mode().Perm())
+	}
+
+	// No temporary files should be left behind
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("Expected only the credentials file, found %d entries", len(entries))
+	}
+}
+
+// Test writeFileAtomic replaces the target of a symlink and keeps the link
+func TestWriteFileAtomicSymlink(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "dotfiles", "credentials")
+	os.MkdirAll(filepath.Dir(target), 0700)
+	os.WriteFile(target, []byte("[old]\n"), 0600)
+	link := filepath.Join(dir, "credentials")
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("Symlinks are not supported: %v", err)
+	}
+
+	if err := writeFileAtomic(link, []byte("[new]\n"), 0600); err != nil {
+		t.Fatalf("writeFileAtomic failed: %v", err)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("Expected %s to still be a symlink", link)
+	}
+	if data, _ := os.ReadFile(target); string(data) != "[new]\n" {
+		t.Errorf("Expected the link target to be updated, got %q", data)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("Expected no temporary files beside the link, found %d entries", len(entries))
+	}
+}
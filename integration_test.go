@@ -9,6 +9,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 // This test requires actual AWS credentials and will make real AWS API calls
@@ -32,20 +33,16 @@ func TestIntegration(t *testing.T) {
 	newProfile := "awsomecreds-test-profile"
 
 	// Run the actual function
-	err := generateTempProfile(sourceProfile, roleArn, mfaToken, newProfile, "", 3600)
+	opts := &roleOptions{SourceProfile: sourceProfile, RoleArns: []string{roleArn}, MFAToken: mfaToken, Duration: 3600, NoCache: true}
+	err := generateTempProfile(opts, newProfile)
 	if err != nil {
 		t.Errorf("Integration test failed: %v", err)
 	}
 
 	// Clean up the test profile
-	cmd := execCommand("aws", "configure", "rm", "--profile", newProfile, "aws_access_key_id")
-	cmd.Run()
-	cmd = execCommand("aws", "configure", "rm", "--profile", newProfile, "aws_secret_access_key")
-	cmd.Run()
-	cmd = execCommand("aws", "configure", "rm", "--profile", newProfile, "aws_session_token")
-	cmd.Run()
-	cmd = execCommand("aws", "configure", "rm", "--profile", newProfile, "region")
-	cmd.Run()
+	if err := cleanupProfiles(&cleanupOptions{Profiles: []string{newProfile}}, io.Discard, time.Now()); err != nil {
+		t.Errorf("Failed to remove test profile: %v", err)
+	}
 }
 
 // Test the generate command with integration
@@ -79,7 +76,8 @@ func TestGenerateCommandIntegration(t *testing.T) {
 		os.Stdout = stdoutW
 
 		// Run the actual function
-		err := outputTempCredentials(sourceProfile, roleArn, mfaToken, region, 3600, "shell")
+		opts := &roleOptions{SourceProfile: sourceProfile, RoleArns: []string{roleArn}, MFAToken: mfaToken, Region: region, Duration: 3600, NoCache: true}
+		err := outputTempCredentials(opts, &outputOptions{Format: "shell"})
 
 		// Close the write end of the pipes to complete the capture
 		stdoutW.Close()
@@ -127,7 +125,8 @@ func TestGenerateCommandIntegration(t *testing.T) {
 		os.Stdout = stdoutW
 
 		// Run the actual function
-		err := outputTempCredentials(sourceProfile, roleArn, mfaToken, region, 3600, "json")
+		opts := &roleOptions{SourceProfile: sourceProfile, RoleArns: []string{roleArn}, MFAToken: mfaToken, Region: region, Duration: 3600, NoCache: true}
+		err := outputTempCredentials(opts, &outputOptions{Format: "json"})
 
 		// Close the write end of the pipes to complete the capture
 		stdoutW.Close()
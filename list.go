@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// profileStatus describes a managed profile for the list command
+type profileStatus struct {
+	Profile          string    `json:"Profile"`
+	RoleArns         []string  `json:"RoleArns,omitempty"`
+	Source           string    `json:"Source"`
+	Region           string    `json:"Region,omitempty"`
+	CreatedAt        time.Time `json:"CreatedAt"`
+	Expiration       time.Time `json:"Expiration"`
+	RemainingSeconds int64     `json:"RemainingSeconds"`
+	Expired          bool      `json:"Expired"`
+	// Missing marks profiles that were removed from the credentials file by hand
+	Missing bool `json:"Missing"`
+}
+
+// listManagedProfiles writes the managed profiles and their remaining
+// lifetime at now to w as a table or JSON
+func listManagedProfiles(w io.Writer, format string, now time.Time) error {
+	profiles, err := loadManagedProfiles()
+	if err != nil {
+		return err
+	}
+
+	statuses := make([]profileStatus, 0, len(profiles))
+	for _, profile := range profiles {
+		status := profileStatus{
+			Profile:    profile.Profile,
+			Source:     profileSource(&profile.Options),
+			Region:     profile.Options.Region,
+			CreatedAt:  profile.CreatedAt,
+			Expiration: profile.Expiration,
+			Expired:    !now.Before(profile.Expiration),
+		}
+		for _, spec := range profile.Options.RoleArns {
+			arn, _, _ := strings.Cut(spec, "#")
+			status.RoleArns = append(status.RoleArns, arn)
+		}
+		if remaining := profile.Expiration.Sub(now); remaining > 0 {
+			status.RemainingSeconds = int64(remaining.Seconds())
+		}
+		if _, found, err := loadProfile(profile.Profile); err == nil && !found {
+			status.Missing = true
+		}
+		statuses = append(statuses, status)
+	}
+
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(statuses)
+	case "table":
+		if len(statuses) == 0 {
+			fmt.Fprintln(w, "No profiles generated by awsomecreds")
+			return nil
+		}
+		table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "PROFILE\tROLE\tSOURCE\tEXPIRES\tREMAINING\tSTATUS")
+		for _, status := range statuses {
+			role := "-"
+			if len(status.RoleArns) > 0 {
+				role = status.RoleArns[len(status.RoleArns)-1]
+			}
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", status.Profile, role, status.Source,
+				status.Expiration.Local().Format("2006-01-02 15:04:05 MST"), formatRemaining(status.RemainingSeconds), status.state())
+		}
+		return table.Flush()
+	default:
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+// state summarizes the status for the table
+func (s profileStatus) state() string {
+	switch {
+	case s.Missing:
+		return "missing"
+	case s.Expired:
+		return "expired"
+	default:
+		return "valid"
+	}
+}
+
+// profileSource names where the credentials of a profile come from
+func profileSource(opts *roleOptions) string {
+	switch {
+	case usesWebIdentity(opts):
+		return "web-identity"
+	case usesSAML(opts):
+		return "saml"
+	case opts.SSOStartURL != "":
+		return "sso"
+	case opts.SourceProfile != "":
+		return opts.SourceProfile
+	default:
+		return "default"
+	}
+}
+
+// formatRemaining formats a remaining lifetime like 1h 05m
+func formatRemaining(seconds int64) string {
+	if seconds <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%dh %02dm", seconds/3600, seconds%3600/60)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test the table and JSON listings of managed profiles
+func TestListManagedProfiles(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	profiles := []struct {
+		name       string
+		opts       *roleOptions
+		expiration time.Time
+		write      bool
+	}{
+		{"prod", &roleOptions{SourceProfile: "corp", RoleArns: []string{"arn:aws:iam::111111111111:role/hub", "arn:aws:iam::222222222222:role/admin#mfa"}}, now.Add(65 * time.Minute), true},
+		{"old", &roleOptions{RoleArns: []string{"arn:aws:iam::123456789012:role/reader"}}, now.Add(-time.Hour), true},
+		{"gone", &roleOptions{SSOStartURL: "https://example.awsapps.com/start"}, now.Add(time.Hour), false},
+	}
+	for _, p := range profiles {
+		credentials := &Credentials{AccessKeyId: "ASIA" + strings.ToUpper(p.name), Expiration: p.expiration}
+		if p.write {
+			if _, err := writeProfile(p.name, [][2]string{{"aws_access_key_id", credentials.AccessKeyId}}, nil); err != nil {
+				t.Fatalf("writeProfile failed: %v", err)
+			}
+		}
+		if err := recordManagedProfile(p.name, p.opts, credentials, profileWrite{}); err != nil {
+			t.Fatalf("recordManagedProfile failed: %v", err)
+		}
+	}
+
+	var table bytes.Buffer
+	if err := listManagedProfiles(&table, "table", now); err != nil {
+		t.Fatalf("listManagedProfiles failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(table.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "PROFILE") {
+		t.Fatalf("Unexpected table:\n%s", table.String())
+	}
+	expected := []struct{ prefix, fields string }{
+		{"gone", "sso"},
+		{"old", "arn:aws:iam::123456789012:role/reader default"},
+		{"prod", "arn:aws:iam::222222222222:role/admin corp"},
+	}
+	for i, e := range expected {
+		if fields := strings.Join(strings.Fields(lines[i+1]), " "); !strings.HasPrefix(fields, e.prefix+" ") || !strings.Contains(fields, e.fields) {
+			t.Errorf("Unexpected row %q, expected %s with %s", lines[i+1], e.prefix, e.fields)
+		}
+	}
+	normalize := func(line string) string { return strings.Join(strings.Fields(line), " ") }
+	if !strings.HasSuffix(lines[1], "missing") || !strings.HasSuffix(normalize(lines[2]), "- expired") || !strings.HasSuffix(normalize(lines[3]), "1h 05m valid") {
+		t.Errorf("Unexpected states:\n%s", table.String())
+	}
+
+	var output bytes.Buffer
+	if err := listManagedProfiles(&output, "json", now); err != nil {
+		t.Fatalf("listManagedProfiles failed: %v", err)
+	}
+	var statuses []profileStatus
+	if err := json.Unmarshal(output.Bytes(), &statuses); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if len(statuses) != 3 || !statuses[1].Expired || statuses[2].RemainingSeconds != 3900 || len(statuses[2].RoleArns) != 2 {
+		t.Errorf("Unexpected statuses %+v", statuses)
+	}
+}
+
+// Test the listing without any managed profiles
+func TestListManagedProfilesEmpty(t *testing.T) {
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+
+	var table bytes.Buffer
+	if err := listManagedProfiles(&table, "table", time.Now()); err != nil || !strings.Contains(table.String(), "No profiles") {
+		t.Errorf("Unexpected listing %q, error %v", table.String(), err)
+	}
+	var output bytes.Buffer
+	if err := listManagedProfiles(&output, "json", time.Now()); err != nil || strings.TrimSpace(output.String()) != "[]" {
+		t.Errorf("Expected an empty JSON array, got %q, error %v", output.String(), err)
+	}
+	if err := listManagedProfiles(&output, "yaml", time.Now()); err == nil {
+		t.Errorf("Expected an error for an unsupported format")
+	}
+}
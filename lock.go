@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// sharedFilesLockFile returns the lock file that guards the shared credentials
+// and config files and the registries kept beside them
+func sharedFilesLockFile() string {
+	return filepath.Join(filepath.Dir(sharedCredentialsFile()), "awsomecreds.lock")
+}
+
+// withSharedFilesLock runs update while holding an advisory lock on the
+// shared files, so the refresh daemon, generate-profile and cleanup never
+// lose each other's changes. Locks are not reentrant: update must not take
+// the lock again.
+func withSharedFilesLock(update func() error) error {
+	path := sharedFilesLockFile()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := lockFile(file); err != nil {
+		return fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	defer unlockFile(file)
+	return update()
+}
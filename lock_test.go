@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Test that a second holder of the lock waits for the first
+func TestWithSharedFilesLock(t *testing.T) {
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "aws", "credentials"))
+
+	held, release, done := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	go withSharedFilesLock(func() error {
+		close(held)
+		<-release
+		return nil
+	})
+	<-held
+
+	go func() {
+		withSharedFilesLock(func() error { return nil })
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("Expected the second lock to wait while the first is held")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the second lock once the first was released")
+	}
+}
+
+// Test that concurrent updates of a registry don't lose each other's entries
+func TestSharedFilesLockConcurrentUpdates(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			path := filepath.Join(dir, fmt.Sprintf("creds-%d.json", i))
+			if err := recordManagedOutFile(path, []byte("{}"), time.Now().Add(time.Hour)); err != nil {
+				t.Errorf("recordManagedOutFile failed: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if files, err := loadManagedOutFiles(); err != nil || len(files) != 20 {
+		t.Errorf("Expected all 20 files to be recorded, got %d (%v)", len(files), err)
+	}
+}
@@ -1,33 +1,47 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
 func main() {
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
+		// exec passes on the exit code of its command, which has reported its own errors
+		var exitErr *exitCodeError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
 
 var (
-	sourceProfile string
-	roleArn       string
-	mfaToken      string
-	newProfile    string
-	region        string
-	duration      int
-	outputFormat  string
+	roleOpts       roleOptions
+	newProfile     string
+	outputOpts     outputOptions
+	sessionOpts    roleOptions
+	sessionProfile string
+	serveAddr      string
+	execServer     bool
+	serveIMDS      bool
+	daemonOpts     daemonOptions
+	listFormat     string
+	cleanupOpts    cleanupOptions
+	unsetFormat    string
 )
 
 var rootCmd = &cobra.Command{
 	Use:   "awsomecreds",
 	Short: "Assume roles and generate temporary AWS credential profiles",
-	Long:  `AWSomeCreds is a CLI tool that generates temporary AWS credentials using AWS STS and sets them using the AWS CLI. It allows you to assume roles with or without MFA authentication and create temporary profiles for tools that support AWS CLI profiles.`,
+	Long: `AWSomeCreds is a CLI tool that generates temporary AWS credentials using AWS STS and writes them as AWS CLI profiles. It allows you to assume roles with or without MFA authentication and create temporary profiles for tools that support AWS CLI profiles.
+
+STS is called natively by default. Use --backend cli to call STS through the aws CLI instead.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		// If no subcommand is provided, show help
 		cmd.Help()
@@ -35,7 +49,7 @@ var rootCmd = &cobra.Command{
 }
 
 var generateProfileCmd = &cobra.Command{
-	Use:   "generate-profile",
+	Use:   "generate-profile [target]",
 	Short: "Generate a temporary AWS credential profile",
 	Long: `Generate a temporary AWS credential profile by assuming a role.
 Supports both MFA and non-MFA authentication methods.
@@ -48,15 +62,23 @@ Examples:
   # Using a specific source profile without MFA
   awsomecreds generate-profile -s my-source-profile -r arn:aws:iam::123456789012:role/my-role -n my-temp-profile
 
+  # Using a target from the config file, overriding its region
+  awsomecreds generate-profile prod-admin -n my-temp-profile --region eu-west-1
+
   # Specifying region and duration
-  awsomecreds generate-profile -r arn:aws:iam::123456789012:role/my-role -n my-temp-profile --region us-west-2 -d 7200`,
+  awsomecreds generate-profile -r arn:aws:iam::123456789012:role/my-role -n my-temp-profile --region us-west-2 -d 7200
+
+  # Chaining from a hub role to a workload role
+  awsomecreds generate-profile -r 'arn:aws:iam::111111111111:role/hub#mfa' -r arn:aws:iam::222222222222:role/workload -m 123456 -n my-temp-profile`,
+	Args:    cobra.MaximumNArgs(1),
+	PreRunE: applyTargetArg,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return generateTempProfile(sourceProfile, roleArn, mfaToken, newProfile, region, duration)
+		return generateTempProfile(&roleOpts, newProfile)
 	},
 }
 
 var generateCmd = &cobra.Command{
-	Use:   "generate",
+	Use:   "generate [target]",
 	Short: "Generate temporary AWS credentials and output to shell",
 	Long: `Generate temporary AWS credentials by assuming a role and output to stdout
 for setting as environment variables in the parent shell.
@@ -69,40 +91,380 @@ Examples:
   # Using a specific source profile without MFA
   eval $(awsomecreds generate -s my-source-profile -r arn:aws:iam::123456789012:role/my-role)
 
+  # Using a target from the config file
+  eval $(awsomecreds generate prod-admin)
+
   # Specifying region and duration
   eval $(awsomecreds generate -r arn:aws:iam::123456789012:role/my-role --region us-west-2 -d 7200)
 
+  # Chaining from a hub role to a third-party role with an external ID
+  eval $(awsomecreds generate -r arn:aws:iam::111111111111:role/hub -r 'arn:aws:iam::222222222222:role/vendor#external-id=abc')
+
+  # Set the variables in fish or PowerShell
+  awsomecreds generate -r arn:aws:iam::123456789012:role/my-role -o fish | source
+  awsomecreds generate -r arn:aws:iam::123456789012:role/my-role -o powershell | Invoke-Expression
+
+  # Pass the credentials to later steps of a GitHub Actions job, masked
+  awsomecreds generate -r arn:aws:iam::123456789012:role/my-role -o github
+
+  # Write a GitLab dotenv report or a docker --env-file
+  awsomecreds generate -r arn:aws:iam::123456789012:role/my-role -o gitlab > build.env
+
+  # Render the credentials with a Go template
+  awsomecreds generate -r arn:aws:iam::123456789012:role/my-role -o template \
+    --template 'spark.hadoop.fs.s3a.access.key={{.AccessKeyId}}{{"\n"}}'
+
+  # Write a dotenv file that the daemon or cleanup deletes once it expires
+  awsomecreds generate -r arn:aws:iam::123456789012:role/my-role -o dotenv --out-file .env --out-file-ttl
+
   # Get credentials in JSON format
-  awsomecreds generate -r arn:aws:iam::123456789012:role/my-role -o json`,
+  awsomecreds generate -r arn:aws:iam::123456789012:role/my-role -o json
+
+  # Get credentials in the credential_process JSON format
+  awsomecreds generate -r arn:aws:iam::123456789012:role/my-role -o credential-process`,
+	Args:    cobra.MaximumNArgs(1),
+	PreRunE: applyTargetArg,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return outputTempCredentials(&roleOpts, &outputOpts)
+	},
+}
+
+var credentialProcessCmd = &cobra.Command{
+	Use:   "credential-process [target]",
+	Short: "Output temporary AWS credentials for the credential_process setting",
+	Long: `Generate temporary AWS credentials by assuming a role and output them in the
+JSON format expected by the credential_process setting of ~/.aws/config.
+Nothing is written to stderr unless an error occurs.
+
+Examples:
+  # In ~/.aws/config
+  [profile my-role]
+  credential_process = awsomecreds credential-process -s my-source-profile -r arn:aws:iam::123456789012:role/my-role`,
+	SilenceUsage: true,
+	Args:         cobra.MaximumNArgs(1),
+	PreRunE:      applyTargetArg,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return outputTempCredentials(&roleOpts, &outputOptions{Format: "credential-process"})
+	},
+}
+
+var sessionCmd = &cobra.Command{
+	Use:   "session",
+	Short: "Start an MFA-authenticated base session for later role assumptions",
+	Long: `Get temporary credentials for the source profile with GetSessionToken using an
+MFA code, and cache them. Until the session expires (up to 36 hours), roles that
+require MFA are assumed with it, so no further MFA codes are needed.
+The source profile must hold the credentials of an IAM user.
+
+Examples:
+  # Start a 12 hour session for the default profile
+  awsomecreds session -m 123456
+
+  # Start a 36 hour session and also write it as a profile
+  awsomecreds session -s my-source-profile -d 129600 -n my-source-profile-mfa`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return generateMFASession(&sessionOpts, sessionProfile)
+	},
+}
+
+var execCmd = &cobra.Command{
+	Use:   "exec [flags] [target --] command [args...]",
+	Short: "Run a command with temporary AWS credentials",
+	Long: `Assume a role and run a command with the temporary credentials in its
+environment. Unlike eval $(awsomecreds generate ...), the credentials are only
+visible to the command. AWS_PROFILE is removed from its environment, signals
+are forwarded to it and awsomecreds exits with its exit code.
+
+Examples:
+  # Run terraform with a role
+  awsomecreds exec -r arn:aws:iam::123456789012:role/my-role -- terraform plan
+
+  # Using a target from the config file
+  awsomecreds exec prod-admin -- terraform plan
+
+  # Using a specific source profile with MFA
+  awsomecreds exec -s my-source-profile -r arn:aws:iam::123456789012:role/my-role --mfa-prompt -- aws s3 ls`,
+	Args:          cobra.MinimumNArgs(1),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if target := execTarget(args, cmd.ArgsLenAtDash(), cmd.Flags().Changed); target != "" {
+			if err := applyTarget(&roleOpts, target, cmd.Flags().Changed); err != nil {
+				return err
+			}
+			args = args[2:]
+		}
+		return runWithCredentials(&roleOpts, args, execServer)
+	},
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve [target]",
+	Short: "Serve temporary AWS credentials to local processes",
+	Long: `Assume a role and serve its credentials on a loopback address with the ECS
+container credentials protocol, which the AWS SDKs and the aws CLI support.
+The credentials are refreshed by assuming the role again before they expire,
+so long-running processes never see expired credentials. Requests must carry
+a random token generated at startup.
+
+With --imds the server emulates the EC2 instance metadata service (IMDSv2)
+instead, for tools that only look for instance role credentials. It may listen
+on any address, e.g. a Docker bridge, so containers can reach it.
+
+The variables that point SDKs at the server are printed on stdout.
+
+Examples:
+  # Serve a role and point another terminal at it
+  awsomecreds serve -r arn:aws:iam::123456789012:role/my-role
+
+  # Listen on a fixed port
+  awsomecreds serve -r arn:aws:iam::123456789012:role/my-role --listen 127.0.0.1:9911
+
+  # Emulate instance metadata for containers on the default Docker bridge
+  awsomecreds serve -r arn:aws:iam::123456789012:role/my-role --imds --listen 172.17.0.1:9912`,
+	Args:    cobra.MaximumNArgs(1),
+	PreRunE: applyTargetArg,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return serveCredentials(&roleOpts, serveAddr, serveIMDS)
+	},
+}
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Refresh generated profiles before they expire",
+	Long: `Watch the profiles written by generate-profile and assume their roles again
+shortly before the credentials expire, rewriting the profiles in place.
+Profiles that need a human, such as roles that require an MFA code without a
+cached MFA session, are logged once per expiration and passed to the notify
+command if one is given. Files written by generate --out-file --out-file-ttl
+are deleted once they expire.
+
+The daemon logs to stderr and stops on SIGINT or SIGTERM, so it can run as a
+systemd service. Use --once to check the profiles a single time from a timer.
+
+Examples:
+  # Refresh profiles 10 minutes before they expire
+  awsomecreds daemon
+
+  # Run under an init system and send desktop notifications
+  awsomecreds daemon --pid-file /run/user/1000/awsomecreds.pid --notify-command notify-send`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRefreshDaemon(&daemonOpts)
+	},
+}
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the profiles generated by awsomecreds",
+	Long: `List the profiles written by generate-profile with their role, source, expiration
+and remaining lifetime. Profiles that were removed from the credentials file by
+hand are shown as missing.
+
+Examples:
+  awsomecreds list
+  awsomecreds list -o json`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return listManagedProfiles(os.Stdout, listFormat, time.Now())
+	},
+}
+
+var cleanupCmd = &cobra.Command{
+	Use:   "cleanup",
+	Short: "Remove expired profiles generated by awsomecreds",
+	Long: `Remove the profiles written by generate-profile from the shared credentials
+and config files once they have expired. Only sections and settings awsomecreds
+wrote are removed; everything else in the files is left exactly as it was.
+Profiles whose credentials were replaced by something else are forgotten but
+not removed. Files written by generate --out-file --out-file-ttl are deleted
+once they have expired too.
+
+Examples:
+  # Remove expired profiles
+  awsomecreds cleanup
+
+  # Show what would be removed without changing anything
+  awsomecreds cleanup --all --dry-run
+
+  # Remove one profile whether or not it has expired
+  awsomecreds cleanup --profile my-temp-profile`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		now := time.Now()
+		if err := cleanupProfiles(&cleanupOpts, os.Stdout, now); err != nil {
+			return err
+		}
+		return cleanupOutFiles(&cleanupOpts, os.Stdout, now)
+	},
+}
+
+var unsetCmd = &cobra.Command{
+	Use:   "unset",
+	Short: "Output commands that clear the variables set by generate",
+	Long: `Output the commands that clear the credential and region variables set by
+generate, in the same shell dialects.
+
+Examples:
+  eval $(awsomecreds unset)
+  awsomecreds unset -o fish | source
+  awsomecreds unset -o powershell | Invoke-Expression`,
+	Args: cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return outputTempCredentials(sourceProfile, roleArn, mfaToken, region, duration, outputFormat)
+		dialect, ok := resolveShellDialect(unsetFormat)
+		if !ok {
+			return fmt.Errorf("unsupported shell: %s (expected auto, %s)", unsetFormat, shellDialectNames())
+		}
+		writeShellUnset(os.Stdout, dialect)
+		return nil
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(generateProfileCmd)
 	rootCmd.AddCommand(generateCmd)
+	rootCmd.AddCommand(credentialProcessCmd)
+	rootCmd.AddCommand(sessionCmd)
+	rootCmd.AddCommand(execCmd)
+	rootCmd.AddCommand(serveCmd)
+	rootCmd.AddCommand(daemonCmd)
+	rootCmd.AddCommand(listCmd)
+	rootCmd.AddCommand(cleanupCmd)
+	rootCmd.AddCommand(unsetCmd)
+
+	// Define flags shared by every command
+	rootCmd.PersistentFlags().StringVar(&stsBackend, "backend", backendNative, "How to call AWS: 'native' to sign requests directly or 'cli' to run the aws CLI")
 
 	// Define flags for the generate-profile command
-	generateProfileCmd.Flags().StringVarP(&sourceProfile, "source-profile", "s", "", "The AWS profile to use as the source for authentication (optional, uses default profile if not specified)")
-	generateProfileCmd.Flags().StringVarP(&roleArn, "role-arn", "r", "", "The ARN of the role to assume (required)")
-	generateProfileCmd.Flags().StringVarP(&mfaToken, "mfa-token", "m", "", "The MFA token code (optional, required only if the role requires MFA)")
+	addRoleFlags(generateProfileCmd, "AWS region to use for the new profile (optional, uses source profile's region if not specified)")
 	generateProfileCmd.Flags().StringVarP(&newProfile, "new-profile", "n", "", "The name for the new profile to create (required)")
-	generateProfileCmd.Flags().StringVarP(&region, "region", "", "", "AWS region to use for the new profile (optional, uses source profile's region if not specified)")
-	generateProfileCmd.Flags().IntVarP(&duration, "duration", "d", 3600, "Session duration in seconds (900-43200, default is 3600/1 hour)")
 
 	// Mark required flags
-	generateProfileCmd.MarkFlagRequired("role-arn")
 	generateProfileCmd.MarkFlagRequired("new-profile")
 
 	// Define flags for the generate command
-	generateCmd.Flags().StringVarP(&sourceProfile, "source-profile", "s", "", "The AWS profile to use as the source for authentication (optional, uses default profile if not specified)")
-	generateCmd.Flags().StringVarP(&roleArn, "role-arn", "r", "", "The ARN of the role to assume (required)")
-	generateCmd.Flags().StringVarP(&mfaToken, "mfa-token", "m", "", "The MFA token code (optional, required only if the role requires MFA)")
-	generateCmd.Flags().StringVarP(&region, "region", "", "", "AWS region to use for the new profile (optional, uses source profile's region if not specified)")
-	generateCmd.Flags().IntVarP(&duration, "duration", "d", 3600, "Session duration in seconds (900-43200, default is 3600/1 hour)")
-	generateCmd.Flags().StringVarP(&outputFormat, "output", "o", "shell", "Output format: 'shell' for shell environment variables or 'json' for JSON format")
+	addRoleFlags(generateCmd, "AWS region to use for the new profile (optional, uses source profile's region if not specified)")
+	generateCmd.Flags().StringVarP(&outputOpts.Format, "output", "o", "shell", "Output format: 'shell' for POSIX shell environment variables, 'fish', 'csh', 'powershell', 'cmd' or 'nushell' for other shells, 'auto' to pick the shell from $SHELL, 'github' to append to $GITHUB_ENV, 'gitlab' for a GitLab dotenv report, 'dotenv' for KEY=VALUE lines, 'template' for --template, 'json' for JSON format or 'credential-process' for the credential_process format")
+	generateCmd.Flags().StringVar(&outputOpts.Template, "template", "", "Go text/template to render the credentials with, for -o template")
+	generateCmd.Flags().StringVar(&outputOpts.TemplateFile, "template-file", "", "File holding the Go text/template to render the credentials with, for -o template")
+	generateCmd.MarkFlagsMutuallyExclusive("template", "template-file")
+	generateCmd.Flags().StringVar(&outputOpts.OutFile, "out-file", "", "Write the output to this file, readable only by you, instead of stdout")
+	generateCmd.Flags().BoolVar(&outputOpts.OutFileTTL, "out-file-ttl", false, "Delete the --out-file once the credentials expire, by the daemon or cleanup command")
 
-	// Mark required flags
-	generateCmd.MarkFlagRequired("role-arn")
+	// Define flags for the credential-process command
+	addRoleFlags(credentialProcessCmd, "AWS region to use (optional, uses source profile's region if not specified)")
+
+	// Define flags for the exec command; everything after the command belongs to it
+	addRoleFlags(execCmd, "AWS region to set for the command (optional, uses source profile's region if not specified)")
+	execCmd.Flags().BoolVar(&execServer, "server", false, "Give the command a local credentials server that refreshes the credentials instead of static keys")
+	execCmd.Flags().SetInterspersed(false)
+
+	// Define flags for the serve command
+	addRoleFlags(serveCmd, "AWS region to use (optional, uses source profile's region if not specified)")
+	serveCmd.Flags().StringVar(&serveAddr, "listen", defaultServeAddr, "The address and port to listen on, which must be a loopback address unless --imds is given (port 0 picks a free port)")
+	serveCmd.Flags().BoolVar(&serveIMDS, "imds", false, "Emulate the EC2 instance metadata service (IMDSv2) instead of the ECS container credentials endpoint")
+
+	// Define flags for the daemon command
+	daemonCmd.Flags().DurationVar(&daemonOpts.RefreshBefore, "refresh-before", defaultRefreshBefore, "Refresh profiles this long before they expire")
+	daemonCmd.Flags().DurationVar(&daemonOpts.Interval, "interval", defaultDaemonInterval, "How often to check the profiles")
+	daemonCmd.Flags().StringVar(&daemonOpts.PIDFile, "pid-file", "", "Write the process ID to this file while running (optional)")
+	daemonCmd.Flags().StringVar(&daemonOpts.NotifyCommand, "notify-command", "", "Run this program with a message when a profile needs a human, e.g. notify-send (optional)")
+	daemonCmd.Flags().BoolVar(&daemonOpts.Once, "once", false, "Check the profiles once and exit")
+
+	// Define flags for the list command
+	listCmd.Flags().StringVarP(&listFormat, "output", "o", "table", "Output format: 'table' or 'json'")
+
+	// Define flags for the cleanup command
+	cleanupCmd.Flags().BoolVar(&cleanupOpts.All, "all", false, "Remove every profile generated by awsomecreds, not only expired ones")
+	cleanupCmd.Flags().StringArrayVar(&cleanupOpts.Profiles, "profile", nil, "Remove this profile whether or not it has expired (repeatable)")
+	cleanupCmd.Flags().BoolVar(&cleanupOpts.DryRun, "dry-run", false, "Show what would be removed without changing any files")
+	cleanupCmd.MarkFlagsMutuallyExclusive("all", "profile")
+
+	// Define flags for the unset command
+	unsetCmd.Flags().StringVarP(&unsetFormat, "output", "o", "shell", "Shell dialect: 'shell' for POSIX shells, 'fish', 'csh', 'powershell', 'cmd', 'nushell' or 'auto' to pick it from $SHELL")
+
+	// Define flags for the session command
+	sessionCmd.Flags().StringVarP(&sessionOpts.SourceProfile, "source-profile", "s", "", "The AWS profile of the IAM user to start the session for (optional, uses default profile if not specified)")
+	sessionCmd.Flags().StringVarP(&sessionOpts.MFAToken, "mfa-token", "m", "", "The MFA token code (optional, prompted for if not specified)")
+	sessionCmd.Flags().StringVar(&sessionOpts.MFASerial, "mfa-serial", "", "The ARN or serial number of the MFA device (optional, defaults to mfa_serial of the source profile)")
+	sessionCmd.Flags().StringVar(&sessionOpts.MFADevice, "mfa-device", "", "The name of the MFA device to use when several are registered")
+	sessionCmd.Flags().IntVarP(&sessionOpts.Duration, "duration", "d", defaultMFASessionDuration, "Session duration in seconds (900-129600, default is 43200/12 hours)")
+	sessionCmd.Flags().StringVarP(&sessionProfile, "new-profile", "n", "", "Also write the session as a profile with this name (optional)")
+	sessionCmd.Flags().StringVarP(&sessionOpts.Region, "region", "", "", "AWS region to use for the new profile (optional, uses source profile's region if not specified)")
+	sessionCmd.MarkFlagsMutuallyExclusive("mfa-serial", "mfa-device")
+}
+
+// applyTargetArg fills roleOpts from the target named by the optional argument
+func applyTargetArg(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	return applyTarget(&roleOpts, args[0], cmd.Flags().Changed)
+}
+
+// roleSourceFlags are the flags that pick the role without a target
+var roleSourceFlags = []string{"role-arn", "web-identity-token-file", "web-identity-token-env", "saml-assertion-file", "saml-assertion-env", "sso-start-url"}
+
+// execTarget returns the target that exec's args start with, or "" if they
+// are only the command. pflag stops at the first argument, so a target
+// arrives with the -- after it; a command with a -- of its own, like
+// git log -- path, is never taken for a target when a flag picks the role.
+func execTarget(args []string, argsLenAtDash int, changed func(flag string) bool) string {
+	if len(args) <= 2 || args[1] != "--" || argsLenAtDash >= 0 {
+		return ""
+	}
+	for _, flag := range roleSourceFlags {
+		if changed(flag) {
+			return ""
+		}
+	}
+	return args[0]
+}
+
+// addRoleFlags defines the flags shared by every command that assumes a role
+func addRoleFlags(cmd *cobra.Command, regionUsage string) {
+	cmd.Flags().StringVarP(&roleOpts.SourceProfile, "source-profile", "s", "", "The AWS profile to use as the source for authentication (optional, uses default profile if not specified)")
+	cmd.Flags().StringArrayVarP(&roleOpts.RoleArns, "role-arn", "r", nil, "The ARN of the role to assume (required unless given by a target, picked from a SAML assertion or using IAM Identity Center). Repeat to chain roles; append #external-id=ID and/or #mfa to set per-role options")
+	cmd.Flags().StringVarP(&roleOpts.MFAToken, "mfa-token", "m", "", "The MFA token code (optional, required only if the role requires MFA)")
+	cmd.Flags().BoolVar(&roleOpts.MFAPrompt, "mfa-prompt", false, "Prompt for the MFA token code on the terminal, or run AWSOMECREDS_ASKPASS when there is none")
+	cmd.Flags().StringVar(&roleOpts.MFASerial, "mfa-serial", "", "The ARN or serial number of the MFA device (optional, defaults to mfa_serial of the source profile)")
+	cmd.Flags().StringVar(&roleOpts.MFADevice, "mfa-device", "", "The name of the MFA device to use when several are registered")
+	cmd.Flags().StringVar(&roleOpts.ExternalID, "external-id", "", "The external ID required by third-party roles (optional, defaults to external_id of the source profile)")
+
+	// Define the web identity flags
+	cmd.Flags().StringVar(&roleOpts.WebIdentityTokenFile, "web-identity-token-file", "", "Assume the first role with the OIDC token in this file instead of a source profile")
+	cmd.Flags().StringVar(&roleOpts.WebIdentityTokenEnv, "web-identity-token-env", "", "Assume the first role with the OIDC token in this environment variable instead of a source profile")
+
+	// Define the SAML flags
+	cmd.Flags().StringVar(&roleOpts.SAMLAssertionFile, "saml-assertion-file", "", "Assume the first role with the base64 SAML response in this file (- for stdin) instead of a source profile")
+	cmd.Flags().StringVar(&roleOpts.SAMLAssertionEnv, "saml-assertion-env", "", "Assume the first role with the base64 SAML response in this environment variable instead of a source profile")
+
+	// Define the IAM Identity Center flags
+	cmd.Flags().StringVar(&roleOpts.SSOStartURL, "sso-start-url", "", "Sign in to IAM Identity Center at this start URL instead of using a source profile")
+	cmd.Flags().StringVar(&roleOpts.SSORegion, "sso-region", "", "The region of IAM Identity Center (defaults to sso_region of the source profile)")
+	cmd.Flags().StringVar(&roleOpts.SSOAccountID, "sso-account-id", "", "The account to get IAM Identity Center credentials for (defaults to sso_account_id of the source profile)")
+	cmd.Flags().StringVar(&roleOpts.SSORoleName, "sso-role-name", "", "The permission set to get IAM Identity Center credentials for (defaults to sso_role_name of the source profile)")
+	cmd.Flags().StringVarP(&roleOpts.Region, "region", "", "", regionUsage)
+	cmd.Flags().IntVarP(&roleOpts.Duration, "duration", "d", 3600, "Session duration in seconds (900-43200, default is 3600/1 hour)")
+
+	// Define the session attribution flags
+	cmd.Flags().StringVar(&roleOpts.SessionName, "session-name", "", "The role session name; {username}, {account} and {timestamp} are replaced (default TempSession-<unix time>)")
+	cmd.Flags().StringVar(&roleOpts.SourceIdentity, "source-identity", "", "The source identity to set on the session; accepts the same placeholders as --session-name")
+	cmd.Flags().StringArrayVar(&roleOpts.Tags, "tag", nil, "A session tag as key=value (repeatable)")
+	cmd.Flags().StringArrayVar(&roleOpts.TransitiveTags, "transitive-tag", nil, "A session tag as key=value that carries over to chained roles (repeatable)")
+
+	// Define the session policy flags
+	cmd.Flags().StringVar(&roleOpts.Policy, "policy", "", "An inline JSON session policy that scopes down the role")
+	cmd.Flags().StringVar(&roleOpts.PolicyFile, "policy-file", "", "A file holding a JSON session policy that scopes down the role")
+	cmd.Flags().StringArrayVar(&roleOpts.PolicyArns, "policy-arn", nil, "The ARN of a managed policy to use as a session policy (repeatable)")
+
+	// Define the credential cache flags
+	cmd.Flags().BoolVar(&roleOpts.NoCache, "no-cache", false, "Neither read nor write the credential cache")
+	cmd.Flags().BoolVar(&roleOpts.ForceRefresh, "force-refresh", false, "Assume the role again even if cached credentials are still valid")
+	cmd.Flags().DurationVar(&roleOpts.RefreshWindow, "refresh-window", defaultRefreshWindow, "Stop reusing cached credentials this long before they expire")
+
+	// Mark flags that are mutually exclusive
+	cmd.MarkFlagsMutuallyExclusive("mfa-serial", "mfa-device")
+	cmd.MarkFlagsMutuallyExclusive("policy", "policy-file")
+	cmd.MarkFlagsMutuallyExclusive("web-identity-token-file", "web-identity-token-env", "saml-assertion-file", "saml-assertion-env", "sso-start-url", "source-profile")
 }
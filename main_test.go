@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"os"
 	"testing"
@@ -9,6 +10,23 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// TestMain isolates the tests from the AWS files and settings of the user running them
+func TestMain(m *testing.M) {
+	home, err := os.MkdirTemp("", "awsomecreds-test-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create test home directory: %v\n", err)
+		os.Exit(1)
+	}
+	os.Setenv("HOME", home)
+	for _, key := range []string{"AWS_PROFILE", "AWS_DEFAULT_PROFILE", "AWS_SHARED_CREDENTIALS_FILE", "AWS_CONFIG_FILE", "XDG_CACHE_HOME"} {
+		os.Unsetenv(key)
+	}
+
+	code := m.Run()
+	os.RemoveAll(home)
+	os.Exit(code)
+}
+
 // Test the root command
 func TestRootCommand(t *testing.T) {
 	// Create a buffer to capture output
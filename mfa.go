@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// getMFADeviceARN finds the MFA device for the source profile. It uses, in
+// order, --mfa-serial, the mfa_serial setting of the profile, the IAM device
+// listed for the caller (chosen with --mfa-device when there are several) and
+// finally the virtual device named after the IAM user.
+func getMFADeviceARN(opts *roleOptions, profileArg, profileValue string) (string, error) {
+	if opts.MFASerial != "" {
+		return opts.MFASerial, nil
+	}
+
+	if opts.MFADevice == "" {
+		serial, err := sourceProfileValue(profileValue, "mfa_serial")
+		if err != nil {
+			return "", err
+		}
+		if serial != "" {
+			return serial, nil
+		}
+	}
+
+	client, err := newSTSClient(clientProfile(profileArg, profileValue))
+	if err != nil {
+		return "", err
+	}
+
+	devices, listErr := client.ListMFADevices()
+	if listErr == nil && len(devices) > 0 {
+		return selectMFADevice(devices, opts.MFADevice)
+	}
+	if opts.MFADevice != "" {
+		if listErr != nil {
+			return "", fmt.Errorf("cannot choose MFA device %q: %w", opts.MFADevice, listErr)
+		}
+		return "", fmt.Errorf("no MFA devices found to choose %q from", opts.MFADevice)
+	}
+
+	// Fall back to the device name the console suggests, which needs no IAM permissions
+	identity, err := client.GetCallerIdentity()
+	if err != nil {
+		if listErr != nil {
+			return "", listErr
+		}
+		return "", err
+	}
+	serial, err := mfaSerialFromIdentity(identity.Arn)
+	if err != nil {
+		if listErr != nil {
+			return "", fmt.Errorf("%w (and listing MFA devices failed: %v)", err, listErr)
+		}
+		return "", err
+	}
+	return serial, nil
+}
+
+// selectMFADevice picks the device whose name or serial number is name, or
+// the only device when name is empty
+func selectMFADevice(devices []string, name string) (string, error) {
+	if name == "" {
+		if len(devices) == 1 {
+			return devices[0], nil
+		}
+		return "", fmt.Errorf("found %d MFA devices (%s); choose one with --mfa-device or --mfa-serial",
+			len(devices), strings.Join(mfaDeviceNames(devices), ", "))
+	}
+
+	for _, device := range devices {
+		if device == name || mfaDeviceName(device) == name {
+			return device, nil
+		}
+	}
+	return "", fmt.Errorf("MFA device %q not found, available devices: %s", name, strings.Join(mfaDeviceNames(devices), ", "))
+}
+
+// mfaDeviceName returns the name of a virtual MFA device ARN, or the serial
+// number itself for hardware devices
+func mfaDeviceName(serial string) string {
+	return serial[strings.LastIndex(serial, "/")+1:]
+}
+
+// mfaDeviceNames returns the names of devices
+func mfaDeviceNames(devices []string) []string {
+	names := make([]string, len(devices))
+	for i, device := range devices {
+		names[i] = mfaDeviceName(device)
+	}
+	return names
+}
+
+// mfaSerialFromIdentity derives the ARN of the virtual MFA device named after
+// the IAM user in callerArn, e.g. arn:aws:iam::123456789012:mfa/alice
+func mfaSerialFromIdentity(callerArn string) (string, error) {
+	parts := strings.SplitN(callerArn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "iam" || !strings.HasPrefix(parts[5], "user/") {
+		return "", fmt.Errorf("cannot derive an MFA device from %s, which is not an IAM user; pass --mfa-serial", callerArn)
+	}
+	return fmt.Sprintf("arn:%s:iam::%s:mfa/%s", parts[1], parts[4], mfaDeviceName(parts[5])), nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Test choosing between several MFA devices
+func TestSelectMFADevice(t *testing.T) {
+	devices := []string{"arn:aws:iam::123456789012:mfa/alice-phone", "GAHT12345678"}
+	testCases := []struct {
+		name        string
+		devices     []string
+		expected    string
+		expectedErr bool
+	}{
+		{name: "", devices: devices[:1], expected: "arn:aws:iam::123456789012:mfa/alice-phone"},
+		{name: "", devices: devices, expectedErr: true},
+		{name: "alice-phone", devices: devices, expected: "arn:aws:iam::123456789012:mfa/alice-phone"},
+		{name: "GAHT12345678", devices: devices, expected: "GAHT12345678"},
+		{name: "alice-yubikey", devices: devices, expectedErr: true},
+	}
+	for _, tc := range testCases {
+		device, err := selectMFADevice(tc.devices, tc.name)
+		if (err != nil) != tc.expectedErr {
+			t.Errorf("selectMFADevice(%v, %q) error = %v, expectedErr %v", tc.devices, tc.name, err, tc.expectedErr)
+			continue
+		}
+		if device != tc.expected {
+			t.Errorf("selectMFADevice(%v, %q) = %q, expected %q", tc.devices, tc.name, device, tc.expected)
+		}
+	}
+}
+
+// Test deriving the MFA device from the caller identity
+func TestMFASerialFromIdentity(t *testing.T) {
+	testCases := []struct {
+		arn         string
+		expected    string
+		expectedErr bool
+	}{
+		{arn: "arn:aws:iam::123456789012:user/alice", expected: "arn:aws:iam::123456789012:mfa/alice"},
+		{arn: "arn:aws:iam::123456789012:user/engineering/alice", expected: "arn:aws:iam::123456789012:mfa/alice"},
+		{arn: "arn:aws-cn:iam::123456789012:user/alice", expected: "arn:aws-cn:iam::123456789012:mfa/alice"},
+		{arn: "arn:aws:sts::123456789012:assumed-role/Admin/alice", expectedErr: true},
+	}
+	for _, tc := range testCases {
+		serial, err := mfaSerialFromIdentity(tc.arn)
+		if (err != nil) != tc.expectedErr {
+			t.Errorf("mfaSerialFromIdentity(%q) error = %v, expectedErr %v", tc.arn, err, tc.expectedErr)
+			continue
+		}
+		if serial != tc.expected {
+			t.Errorf("mfaSerialFromIdentity(%q) = %q, expected %q", tc.arn, serial, tc.expected)
+		}
+	}
+}
+
+// Test the order in which the MFA device is resolved
+func TestGetMFADeviceARNSources(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_CONFIG_FILE", writeTestFile(t, "config", "[profile mfa-user]\nmfa_serial = arn:aws:iam::123456789012:mfa/from-config\n"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", writeTestFile(t, "credentials", "[mfa-user]\naws_access_key_id = AKIDTEST\naws_secret_access_key = secret\n"))
+
+	identity := `<GetCallerIdentityResponse><GetCallerIdentityResult>
+		<Arn>arn:aws:iam::123456789012:user/alice</Arn><UserId>AIDAMOCK</UserId><Account>123456789012</Account>
+		</GetCallerIdentityResult></GetCallerIdentityResponse>`
+	devices := `<ListMFADevicesResponse><ListMFADevicesResult><MFADevices>
+		<member><SerialNumber>arn:aws:iam::123456789012:mfa/alice-phone</SerialNumber></member>
+		<member><SerialNumber>arn:aws:iam::123456789012:mfa/alice-yubikey</SerialNumber></member>
+		</MFADevices><IsTruncated>false</IsTruncated></ListMFADevicesResult></ListMFADevicesResponse>`
+
+	// Without iam:ListMFADevices the device is derived from the caller identity
+	newMockSTSServer(t, map[string]string{"GetCallerIdentity": identity})
+	serial, err := getMFADeviceARN(&roleOptions{}, "", "")
+	if err != nil || serial != "arn:aws:iam::123456789012:mfa/alice" {
+		t.Errorf("Expected the derived MFA device, got %q, %v", serial, err)
+	}
+
+	// The profile setting wins over the API
+	serial, err = getMFADeviceARN(&roleOptions{}, "--profile", "mfa-user")
+	if err != nil || serial != "arn:aws:iam::123456789012:mfa/from-config" {
+		t.Errorf("Expected the MFA device from the profile, got %q, %v", serial, err)
+	}
+
+	// The flag wins over the profile setting
+	serial, err = getMFADeviceARN(&roleOptions{MFASerial: "GAHT12345678"}, "--profile", "mfa-user")
+	if err != nil || serial != "GAHT12345678" {
+		t.Errorf("Expected the MFA device from --mfa-serial, got %q, %v", serial, err)
+	}
+
+	// Several listed devices need to be chosen by name
+	newMockSTSServer(t, map[string]string{"GetCallerIdentity": identity, "ListMFADevices": devices})
+	if _, err := getMFADeviceARN(&roleOptions{}, "", ""); err == nil || !strings.Contains(err.Error(), "--mfa-device") {
+		t.Errorf("Expected an error asking for --mfa-device, got %v", err)
+	}
+	serial, err = getMFADeviceARN(&roleOptions{MFADevice: "alice-yubikey"}, "--profile", "mfa-user")
+	if err != nil || serial != "arn:aws:iam::123456789012:mfa/alice-yubikey" {
+		t.Errorf("Expected the MFA device chosen by name, got %q, %v", serial, err)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// defaultMFASessionDuration is the default lifetime of a base session, like the aws CLI
+const defaultMFASessionDuration = 43200
+
+// generateMFASession gets an MFA-authenticated base session for the source
+// profile with GetSessionToken and caches it, so later role assumptions that
+// require MFA don't ask for a code. If newProfile is set the session is also
+// written as a profile.
+func generateMFASession(opts *roleOptions, newProfile string) error {
+	profileArg := "--profile"
+	profileValue := opts.SourceProfile
+	if opts.SourceProfile == "" {
+		fmt.Printf("No source profile specified, using default AWS profile\n")
+		profileArg = ""
+	} else {
+		fmt.Printf("Using source profile: %s\n", opts.SourceProfile)
+	}
+
+	fmt.Printf("Getting MFA device ARN...\n")
+	mfaSerial, err := getMFADeviceARN(opts, profileArg, profileValue)
+	if err != nil {
+		return fmt.Errorf("error getting MFA device: %w", err)
+	}
+	if mfaSerial == "" {
+		return fmt.Errorf("no MFA device found, but a session requires MFA")
+	}
+	fmt.Printf("Found MFA device: %s\n", mfaSerial)
+
+	code := opts.MFAToken
+	if code == "" {
+		code, err = readMFACode(mfaSerial)
+		if err != nil {
+			return err
+		}
+	}
+
+	client, err := newSTSClient(clientProfile(profileArg, profileValue))
+	if err != nil {
+		return err
+	}
+	credentials, err := client.GetSessionToken(&getSessionTokenInput{
+		DurationSeconds: opts.Duration,
+		SerialNumber:    mfaSerial,
+		TokenCode:       code,
+	})
+	if err != nil {
+		return fmt.Errorf("error getting session token: %w\n\nSessions can only be started with the credentials of an IAM user, "+
+			"and the duration must be between 900 and 129600 seconds", err)
+	}
+	if credentials.AccessKeyId == "" || credentials.SecretAccessKey == "" || credentials.SessionToken == "" {
+		return fmt.Errorf("failed to get valid credentials from AWS response")
+	}
+
+	if err := saveCachedCredentials(mfaSessionCacheKey(opts.SourceProfile), credentials); err != nil {
+		return fmt.Errorf("error caching MFA session: %w", err)
+	}
+	if newProfile != "" {
+		fmt.Printf("Setting up profile %s...\n", newProfile)
+		err := withSharedFilesLock(func() error {
+			_, err := configureAWSProfile(newProfile, credentials, opts.SourceProfile, opts.Region, os.Stdout)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("error configuring AWS profile: %w", err)
+		}
+	}
+
+	remaining := time.Until(credentials.Expiration)
+	fmt.Printf("MFA session started, it will expire at: %s (valid for approximately %dh %dm)\n",
+		credentials.Expiration.Local().Format("2006-01-02 15:04:05 MST"), int(remaining.Hours()), int(remaining.Minutes())%60)
+	fmt.Printf("Roles that require MFA will be assumed with this session instead of asking for a code\n")
+	return nil
+}
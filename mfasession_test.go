@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Test that a base session from the session command replaces the MFA code of later role assumptions
+func TestMFASession(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		auth := r.Header.Get("Authorization")
+		signer := strings.SplitN(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 Credential="), "/", 2)[0]
+		action := r.PostForm.Get("Action")
+		calls = append(calls, fmt.Sprintf("%s %s %s %s", action, signer, r.PostForm.Get("SerialNumber"), r.PostForm.Get("TokenCode")))
+
+		switch action {
+		case "GetSessionToken":
+			fmt.Fprint(w, `<GetSessionTokenResponse><GetSessionTokenResult><Credentials>
+				<AccessKeyId>ASIASESSION</AccessKeyId><SecretAccessKey>secret</SecretAccessKey>
+				<SessionToken>token</SessionToken><Expiration>2099-01-01T00:00:00Z</Expiration>
+				</Credentials></GetSessionTokenResult></GetSessionTokenResponse>`)
+		case "AssumeRole":
+			fmt.Fprint(w, mockAssumeRoleResponse)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+
+	originalReadMFACode := readMFACode
+	readMFACode = func(device string) (string, error) { return "654321", nil }
+	defer func() { readMFACode = originalReadMFACode }()
+
+	// Start the session, discarding the progress messages
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	err := generateMFASession(&roleOptions{MFASerial: "arn:aws:iam::123456789012:mfa/alice", Duration: defaultMFASessionDuration}, "")
+	w.Close()
+	os.Stdout = oldStdout
+	io.Copy(io.Discard, r)
+	if err != nil {
+		t.Fatalf("generateMFASession failed: %v", err)
+	}
+
+	// Assume a role that requires MFA without a code
+	opts := &roleOptions{
+		RoleArns:      []string{"arn:aws:iam::123456789012:role/TestRole#mfa"},
+		Duration:      3600,
+		RefreshWindow: defaultRefreshWindow,
+		ForceRefresh:  true,
+	}
+	if _, err := obtainCredentials(opts, io.Discard); err != nil {
+		t.Fatalf("obtainCredentials failed: %v", err)
+	}
+
+	// A role not marked with #mfa is assumed with the session as well
+	opts.RoleArns = []string{"arn:aws:iam::123456789012:role/PlainRole"}
+	if _, err := obtainCredentials(opts, io.Discard); err != nil {
+		t.Fatalf("obtainCredentials failed: %v", err)
+	}
+
+	// Without the cache the long-term keys are used
+	opts.NoCache = true
+	if _, err := obtainCredentials(opts, io.Discard); err != nil {
+		t.Fatalf("obtainCredentials failed: %v", err)
+	}
+
+	expected := []string{
+		"GetSessionToken AKIDTEST arn:aws:iam::123456789012:mfa/alice 654321",
+		"AssumeRole ASIASESSION  ",
+		"AssumeRole ASIASESSION  ",
+		"AssumeRole AKIDTEST  ",
+	}
+	if strings.Join(calls, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected calls:\n%s\nexpected:\n%s", strings.Join(calls, "\n"), strings.Join(expected, "\n"))
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// managedOutFile records a file written by generate --out-file --out-file-ttl,
+// to be deleted when its credentials expire
+type managedOutFile struct {
+	Path string `json:"Path"`
+	// SHA256 identifies the content written, so a file rewritten by something else is left alone
+	SHA256     string    `json:"SHA256"`
+	Expiration time.Time `json:"Expiration"`
+}
+
+// outFileRegistryFile returns the file listing the files to delete at
+// expiration, kept beside the profile registry
+func outFileRegistryFile() string {
+	return filepath.Join(filepath.Dir(sharedCredentialsFile()), "awsomecreds-files.json")
+}
+
+// loadManagedOutFiles returns the recorded files sorted by path
+func loadManagedOutFiles() ([]managedOutFile, error) {
+	data, err := os.ReadFile(outFileRegistryFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var files []managedOutFile
+	if err := json.Unmarshal(data, &files); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", outFileRegistryFile(), err)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// saveManagedOutFiles replaces the recorded files, readable only by the current user
+func saveManagedOutFiles(files []managedOutFile) error {
+	data, err := json.MarshalIndent(files, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(outFileRegistryFile(), data, 0600)
+}
+
+// recordManagedOutFile adds or updates the record of the file at path,
+// written with data that expires at expiration
+func recordManagedOutFile(path string, data []byte, expiration time.Time) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	return withSharedFilesLock(func() error {
+		files, err := loadManagedOutFiles()
+		if err != nil {
+			return err
+		}
+
+		record := managedOutFile{Path: path, SHA256: contentDigest(data), Expiration: expiration}
+		for i := range files {
+			if files[i].Path == path {
+				files[i] = record
+				return saveManagedOutFiles(files)
+			}
+		}
+		return saveManagedOutFiles(append(files, record))
+	})
+}
+
+// writeOutFile writes data to path atomically, readable only by the current
+// user, and records it for deletion at expiration if ttl is set
+func writeOutFile(path string, data []byte, expiration time.Time, ttl bool) error {
+	if err := writeFileAtomic(path, data, 0600); err != nil {
+		return err
+	}
+	if !ttl {
+		return nil
+	}
+	if err := recordManagedOutFile(path, data, expiration); err != nil {
+		return fmt.Errorf("wrote %s but failed to record it for deletion: %w", path, err)
+	}
+	return nil
+}
+
+// removeOutFiles deletes the recorded files that expired before now, or all
+// of them. Files that are gone are forgotten, and files rewritten since are
+// forgotten without being deleted. Each file is reported with report.
+func removeOutFiles(now time.Time, all, dryRun bool, report func(format string, args ...interface{})) error {
+	return withSharedFilesLock(func() error {
+		return removeExpiredOutFiles(now, all, dryRun, report)
+	})
+}
+
+// removeExpiredOutFiles does the work of removeOutFiles, with the shared files locked
+func removeExpiredOutFiles(now time.Time, all, dryRun bool, report func(format string, args ...interface{})) error {
+	files, err := loadManagedOutFiles()
+	if err != nil {
+		return err
+	}
+
+	action := "Removed"
+	if dryRun {
+		action = "Would remove"
+	}
+	var kept []managedOutFile
+	for _, file := range files {
+		if !all && now.Before(file.Expiration) {
+			kept = append(kept, file)
+			continue
+		}
+
+		data, err := os.ReadFile(file.Path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			// Already deleted by hand
+		case err != nil:
+			report("Error reading file %s: %v", file.Path, err)
+			kept = append(kept, file)
+		case contentDigest(data) != file.SHA256:
+			report("Forgetting file %s, it was rewritten since awsomecreds wrote it", file.Path)
+		case dryRun:
+			report("%s file %s (expires %s)", action, file.Path, file.Expiration.Local().Format("2006-01-02 15:04:05 MST"))
+		default:
+			if err := os.Remove(file.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				report("Error removing file %s: %v", file.Path, err)
+				kept = append(kept, file)
+				continue
+			}
+			report("%s file %s (expires %s)", action, file.Path, file.Expiration.Local().Format("2006-01-02 15:04:05 MST"))
+		}
+	}
+
+	if dryRun || len(kept) == len(files) {
+		return nil
+	}
+	return saveManagedOutFiles(kept)
+}
+
+// contentDigest returns the hex SHA-256 of data
+func contentDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
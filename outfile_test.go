@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that output files are private, replaced in place and recorded only with a TTL
+func TestWriteOutFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "aws", "credentials"))
+	expiration := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	path := filepath.Join(dir, "creds.env")
+	os.WriteFile(path, []byte("old"), 0644)
+	if err := writeOutFile(path, []byte("AWS_ACCESS_KEY_ID=ASIA1\n"), expiration, false); err != nil {
+		t.Fatalf("writeOutFile failed: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat output file: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+	}
+	if data, _ := os.ReadFile(path); string(data) != "AWS_ACCESS_KEY_ID=ASIA1\n" {
+		t.Errorf("Unexpected content: %q", data)
+	}
+	if files, _ := loadManagedOutFiles(); len(files) != 0 {
+		t.Errorf("Expected no recorded files without a TTL, got %v", files)
+	}
+
+	// Writing the same file again with a TTL updates its single record
+	for _, content := range []string{"first", "second"} {
+		if err := writeOutFile(path, []byte(content), expiration, true); err != nil {
+			t.Fatalf("writeOutFile failed: %v", err)
+		}
+	}
+	files, err := loadManagedOutFiles()
+	if err != nil {
+		t.Fatalf("loadManagedOutFiles failed: %v", err)
+	}
+	if len(files) != 1 || files[0].Path != path || files[0].SHA256 != contentDigest([]byte("second")) || !files[0].Expiration.Equal(expiration) {
+		t.Errorf("Unexpected records: %+v", files)
+	}
+}
+
+// Test which recorded files are deleted and which are forgotten
+func TestRemoveOutFiles(t *testing.T) {
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	setup := func(t *testing.T) string {
+		dir := t.TempDir()
+		t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+		for _, f := range []struct {
+			name       string
+			expiration time.Time
+		}{
+			{"expired", now.Add(-time.Hour)},
+			{"current", now.Add(time.Hour)},
+			{"rewritten", now.Add(-time.Hour)},
+			{"gone", now.Add(-time.Hour)},
+		} {
+			if err := writeOutFile(filepath.Join(dir, f.name), []byte(f.name), f.expiration, true); err != nil {
+				t.Fatalf("writeOutFile failed: %v", err)
+			}
+		}
+		os.WriteFile(filepath.Join(dir, "rewritten"), []byte("changed by hand"), 0600)
+		os.Remove(filepath.Join(dir, "gone"))
+		return dir
+	}
+
+	testCases := []struct {
+		name            string
+		all             bool
+		dryRun          bool
+		expectedExist   []string
+		expectedMissing []string
+		expectedKept    []string
+		expectedReport  []string
+	}{
+		{
+			name:            "Expired",
+			expectedExist:   []string{"current", "rewritten"},
+			expectedMissing: []string{"expired"},
+			expectedKept:    []string{"current"},
+			expectedReport:  []string{"Removed file", "expired", "Forgetting file", "rewritten"},
+		},
+		{
+			name:            "All",
+			all:             true,
+			expectedExist:   []string{"rewritten"},
+			expectedMissing: []string{"expired", "current"},
+			expectedReport:  []string{"Removed file", "current"},
+		},
+		{
+			name:           "Dry run",
+			dryRun:         true,
+			expectedExist:  []string{"expired", "current", "rewritten"},
+			expectedKept:   []string{"current", "expired", "gone", "rewritten"},
+			expectedReport: []string{"Would remove file", "expired"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := setup(t)
+			var report strings.Builder
+			err := removeOutFiles(now, tc.all, tc.dryRun, func(format string, args ...interface{}) {
+				fmt.Fprintf(&report, format+"\n", args...)
+			})
+			if err != nil {
+				t.Fatalf("removeOutFiles failed: %v", err)
+			}
+
+			for _, name := range tc.expectedExist {
+				if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+					t.Errorf("Expected %s to exist: %v", name, err)
+				}
+			}
+			for _, name := range tc.expectedMissing {
+				if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+					t.Errorf("Expected %s to be deleted, got %v", name, err)
+				}
+			}
+
+			files, _ := loadManagedOutFiles()
+			var kept []string
+			for _, file := range files {
+				kept = append(kept, filepath.Base(file.Path))
+			}
+			if strings.Join(kept, ",") != strings.Join(tc.expectedKept, ",") {
+				t.Errorf("Expected records %v, got %v", tc.expectedKept, kept)
+			}
+			for _, expected := range tc.expectedReport {
+				if !strings.Contains(report.String(), expected) {
+					t.Errorf("Expected report to contain %q, got:\n%s", expected, report.String())
+				}
+			}
+		})
+	}
+}
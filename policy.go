@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Limits STS puts on session policies
+const (
+	maxSessionPolicyLength = 2048
+	maxSessionPolicyArns   = 10
+)
+
+// loadSessionPolicy returns the inline session policy from --policy or
+// --policy-file with insignificant whitespace removed, or "" if there is none
+func loadSessionPolicy(opts *roleOptions) (string, error) {
+	policy := []byte(opts.Policy)
+	source := "--policy"
+	if opts.PolicyFile != "" {
+		data, err := os.ReadFile(opts.PolicyFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read policy file: %w", err)
+		}
+		policy = data
+		source = opts.PolicyFile
+	}
+	if len(bytes.TrimSpace(policy)) == 0 {
+		return "", nil
+	}
+
+	// Catch typos here rather than with a generic MalformedPolicyDocument from STS
+	var document map[string]interface{}
+	if err := json.Unmarshal(policy, &document); err != nil {
+		return "", fmt.Errorf("invalid session policy in %s: %w", source, err)
+	}
+	if _, ok := document["Statement"]; !ok {
+		return "", fmt.Errorf("invalid session policy in %s: no Statement", source)
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, policy); err != nil {
+		return "", fmt.Errorf("invalid session policy in %s: %w", source, err)
+	}
+	if compact.Len() > maxSessionPolicyLength {
+		return "", fmt.Errorf("session policy in %s is %d characters, the limit is %d", source, compact.Len(), maxSessionPolicyLength)
+	}
+	return compact.String(), nil
+}
+
+// validatePolicyArns checks the managed policies given with --policy-arn
+func validatePolicyArns(arns []string) error {
+	if len(arns) > maxSessionPolicyArns {
+		return fmt.Errorf("%d managed session policies given, the limit is %d", len(arns), maxSessionPolicyArns)
+	}
+	for _, arn := range arns {
+		if !strings.HasPrefix(arn, "arn:") || !strings.Contains(arn, ":policy/") {
+			return fmt.Errorf("invalid policy ARN %q", arn)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const readOnlyPolicy = `{
+  "Version": "2012-10-17",
+  "Statement": [{"Effect": "Allow", "Action": ["s3:Get*", "s3:List*"], "Resource": "*"}]
+}`
+
+// Test loading and validating inline session policies
+func TestLoadSessionPolicy(t *testing.T) {
+	compact := `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":["s3:Get*","s3:List*"],"Resource":"*"}]}`
+	testCases := []struct {
+		name        string
+		opts        roleOptions
+		expected    string
+		expectedErr bool
+	}{
+		{name: "none", opts: roleOptions{}, expected: ""},
+		{name: "inline", opts: roleOptions{Policy: readOnlyPolicy}, expected: compact},
+		{name: "file", opts: roleOptions{PolicyFile: writeTestFile(t, "policy.json", readOnlyPolicy)}, expected: compact},
+		{name: "missing file", opts: roleOptions{PolicyFile: "/nonexistent/policy.json"}, expectedErr: true},
+		{name: "invalid JSON", opts: roleOptions{Policy: `{"Statement": [}`}, expectedErr: true},
+		{name: "no statement", opts: roleOptions{Policy: `{"Version": "2012-10-17"}`}, expectedErr: true},
+		{name: "too long", opts: roleOptions{Policy: `{"Statement":[],"Sid":"` + strings.Repeat("a", 2048) + `"}`}, expectedErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy, err := loadSessionPolicy(&tc.opts)
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("loadSessionPolicy error = %v, expectedErr %v", err, tc.expectedErr)
+			}
+			if policy != tc.expected {
+				t.Errorf("loadSessionPolicy = %q, expected %q", policy, tc.expected)
+			}
+		})
+	}
+}
+
+// Test validation of --policy-arn values
+func TestValidatePolicyArns(t *testing.T) {
+	if err := validatePolicyArns([]string{"arn:aws:iam::aws:policy/ReadOnlyAccess"}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := validatePolicyArns([]string{"ReadOnlyAccess"}); err == nil {
+		t.Error("Expected an error for a policy name")
+	}
+	if err := validatePolicyArns(make([]string, 11)); err == nil {
+		t.Error("Expected an error for too many policies")
+	}
+}
+
+// Test that session policies only scope down the last role of a chain
+func TestObtainCredentialsSessionPolicy(t *testing.T) {
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		calls = append(calls, fmt.Sprintf("%t %s", r.PostForm.Get("Policy") != "", r.PostForm.Get("PolicyArns.member.1.arn")))
+		fmt.Fprint(w, mockAssumeRoleResponse)
+	}))
+	defer server.Close()
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	opts := &roleOptions{
+		RoleArns:   []string{"arn:aws:iam::111111111111:role/hub", "arn:aws:iam::222222222222:role/workload"},
+		Policy:     readOnlyPolicy,
+		PolicyArns: []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"},
+		Duration:   3600,
+		NoCache:    true,
+	}
+	var status bytes.Buffer
+	if _, err := obtainCredentials(opts, &status); err != nil {
+		t.Fatalf("obtainCredentials failed: %v", err)
+	}
+
+	expected := "false \ntrue arn:aws:iam::aws:policy/ReadOnlyAccess"
+	if strings.Join(calls, "\n") != expected {
+		t.Errorf("Unexpected AssumeRole calls:\n%s\nexpected:\n%s", strings.Join(calls, "\n"), expected)
+	}
+	if !strings.Contains(status.String(), "6% of the packed policy size limit") {
+		t.Errorf("Expected the packed policy size in the status output, got:\n%s", status.String())
+	}
+}
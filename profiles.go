@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// managedProfile records a profile written by generate-profile, with the
+// options to assume its role again
+type managedProfile struct {
+	Profile string `json:"Profile"`
+	// Options never hold an MFA code; MFAPrompt marks profiles that were generated with one
+	Options roleOptions `json:"Options"`
+	// AccessKeyId identifies the credentials written, so a profile rewritten by something else is left alone
+	AccessKeyId string `json:"AccessKeyId"`
+	// CredentialsSection and ConfigSection record what the first write of
+	// the profile did to each file, so cleanup only undoes that
+	CredentialsSection sectionWrite `json:"CredentialsSection"`
+	ConfigSection      sectionWrite `json:"ConfigSection"`
+	Expiration         time.Time    `json:"Expiration"`
+	CreatedAt          time.Time    `json:"CreatedAt"`
+}
+
+// profileRegistryFile returns the file listing the managed profiles, kept
+// beside the shared credentials file it describes
+func profileRegistryFile() string {
+	return filepath.Join(filepath.Dir(sharedCredentialsFile()), "awsomecreds-profiles.json")
+}
+
+// loadManagedProfiles returns the managed profiles sorted by name
+func loadManagedProfiles() ([]managedProfile, error) {
+	data, err := os.ReadFile(profileRegistryFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var profiles []managedProfile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", profileRegistryFile(), err)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Profile < profiles[j].Profile })
+	return profiles, nil
+}
+
+// saveManagedProfiles replaces the managed profiles, readable only by the current user
+func saveManagedProfiles(profiles []managedProfile) error {
+	data, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(profileRegistryFile(), data, 0600)
+}
+
+// recordManagedProfile adds or updates the record of a profile generated
+// with opts and written as write describes. A profile that is regenerated
+// keeps its creation time, and what was done to sections that it didn't
+// create again.
+func recordManagedProfile(profile string, opts *roleOptions, credentials *Credentials, write profileWrite) error {
+	profiles, err := loadManagedProfiles()
+	if err != nil {
+		return err
+	}
+
+	record := managedProfile{
+		Profile:            profile,
+		Options:            *opts,
+		AccessKeyId:        credentials.AccessKeyId,
+		CredentialsSection: write.Credentials,
+		ConfigSection:      write.Config,
+		Expiration:         credentials.Expiration,
+		CreatedAt:          time.Now().UTC(),
+	}
+	// A code can't be used twice, so remember that one is needed instead
+	record.Options.MFAPrompt = opts.MFAPrompt || opts.MFAToken != ""
+	record.Options.MFAToken = ""
+	record.Options.ForceRefresh = false
+
+	for i := range profiles {
+		if profiles[i].Profile == profile {
+			record.CreatedAt = profiles[i].CreatedAt
+			// Our own earlier values are not what the user had before
+			if !write.Credentials.Created {
+				record.CredentialsSection = profiles[i].CredentialsSection
+			}
+			if !write.Config.Created {
+				record.ConfigSection = profiles[i].ConfigSection
+			}
+			profiles[i] = record
+			return saveManagedProfiles(profiles)
+		}
+	}
+	return saveManagedProfiles(append(profiles, record))
+}
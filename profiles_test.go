@@ -0,0 +1,52 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test that generated profiles are recorded without their MFA code
+func TestRecordManagedProfile(t *testing.T) {
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+
+	opts := &roleOptions{
+		SourceProfile: "source",
+		RoleArns:      []string{"arn:aws:iam::123456789012:role/admin"},
+		MFAToken:      "123456",
+		Duration:      3600,
+		ForceRefresh:  true,
+	}
+	first := &Credentials{Expiration: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)}
+	if err := recordManagedProfile("b-profile", opts, first, profileWrite{}); err != nil {
+		t.Fatalf("recordManagedProfile failed: %v", err)
+	}
+	if err := recordManagedProfile("a-profile", &roleOptions{RoleArns: []string{"arn:aws:iam::123456789012:role/reader"}}, first, profileWrite{}); err != nil {
+		t.Fatalf("recordManagedProfile failed: %v", err)
+	}
+
+	profiles, err := loadManagedProfiles()
+	if err != nil {
+		t.Fatalf("loadManagedProfiles failed: %v", err)
+	}
+	if len(profiles) != 2 || profiles[0].Profile != "a-profile" || profiles[1].Profile != "b-profile" {
+		t.Fatalf("Expected profiles sorted by name, got %+v", profiles)
+	}
+	recorded := profiles[1]
+	if recorded.Options.MFAToken != "" || !recorded.Options.MFAPrompt || recorded.Options.ForceRefresh {
+		t.Errorf("Expected the MFA code to be replaced by MFAPrompt, got %+v", recorded.Options)
+	}
+	if recorded.Options.SourceProfile != "source" || recorded.Options.RoleArns[0] != "arn:aws:iam::123456789012:role/admin" {
+		t.Errorf("Unexpected recorded options %+v", recorded.Options)
+	}
+
+	// Regenerating a profile updates its expiration but keeps its creation time
+	second := &Credentials{Expiration: time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC)}
+	if err := recordManagedProfile("b-profile", opts, second, profileWrite{}); err != nil {
+		t.Fatalf("recordManagedProfile failed: %v", err)
+	}
+	profiles, _ = loadManagedProfiles()
+	if len(profiles) != 2 || !profiles[1].Expiration.Equal(second.Expiration) || !profiles[1].CreatedAt.Equal(recorded.CreatedAt) {
+		t.Errorf("Unexpected profile after regenerating: %+v", profiles[1])
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// errNoTerminal is returned when there is no terminal to prompt on
+var errNoTerminal = errors.New("no terminal available")
+
+// readMFACode is a variable so tests can replace the prompt
+var readMFACode = readMFACodeFunc
+
+// readLine is a variable so tests can answer prompts
+var readLine = readLineFunc
+
+// readMFACodeFunc asks for the current code of the MFA device, first on the
+// terminal without echo and otherwise through the AWSOMECREDS_ASKPASS program
+func readMFACodeFunc(device string) (string, error) {
+	prompt := fmt.Sprintf("Enter MFA code for %s: ", device)
+
+	code, err := readSecretFromTerminal(prompt)
+	if errors.Is(err, errNoTerminal) {
+		askpass := os.Getenv("AWSOMECREDS_ASKPASS")
+		if askpass == "" {
+			return "", fmt.Errorf("cannot prompt for the MFA code: %w; pass --mfa-token or set AWSOMECREDS_ASKPASS", err)
+		}
+		code, err = runAskpass(askpass, prompt)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	code = strings.TrimSpace(code)
+	if !isMFACode(code) {
+		return "", fmt.Errorf("invalid MFA code: expected 6 digits")
+	}
+	return code, nil
+}
+
+// readSecretFromTerminal prints prompt on the controlling terminal and reads a
+// line without echoing it. stdout is never used so eval $(...) keeps working.
+func readSecretFromTerminal(prompt string) (string, error) {
+	// Prefer the controlling terminal so prompts work even when stdin is redirected
+	if tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+		defer tty.Close()
+		if term.IsTerminal(int(tty.Fd())) {
+			return readSecret(tty, tty, prompt)
+		}
+	}
+
+	// Fall back to stdin, e.g. on Windows consoles
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		return readSecret(os.Stdin, os.Stderr, prompt)
+	}
+	return "", errNoTerminal
+}
+
+// readSecret reads a line from the terminal in without echo
+func readSecret(in, out *os.File, prompt string) (string, error) {
+	fmt.Fprint(out, prompt)
+	secret, err := term.ReadPassword(int(in.Fd()))
+	fmt.Fprintln(out)
+	if err != nil {
+		return "", fmt.Errorf("failed to read from terminal: %w", err)
+	}
+	return string(secret), nil
+}
+
+// readLineFunc prints prompt on the controlling terminal and reads a line
+// with echo, for questions like which role to assume
+func readLineFunc(prompt string) (string, error) {
+	in, out := os.Stdin, os.Stderr
+	if tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+		defer tty.Close()
+		in, out = tty, tty
+	}
+	if !term.IsTerminal(int(in.Fd())) {
+		return "", errNoTerminal
+	}
+
+	fmt.Fprint(out, prompt)
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("failed to read from terminal: %w", err)
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// runAskpass runs an SSH_ASKPASS style program with prompt as its only
+// argument and returns what it prints on stdout
+func runAskpass(program, prompt string) (string, error) {
+	cmd := execCommand(program, prompt)
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("askpass program %s failed: %w", program, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// isMFACode reports whether code looks like a TOTP code
+func isMFACode(code string) bool {
+	if len(code) != 6 {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os/exec"
+	"testing"
+)
+
+// Test validation of MFA codes
+func TestIsMFACode(t *testing.T) {
+	testCases := []struct {
+		code     string
+		expected bool
+	}{
+		{"123456", true},
+		{"012345", true},
+		{"12345", false},
+		{"1234567", false},
+		{"12a456", false},
+		{"", false},
+	}
+	for _, tc := range testCases {
+		if got := isMFACode(tc.code); got != tc.expected {
+			t.Errorf("isMFACode(%q) = %v, expected %v", tc.code, got, tc.expected)
+		}
+	}
+}
+
+// Test reading the MFA code from an askpass program
+func TestRunAskpass(t *testing.T) {
+	execCommand = mockExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	code, err := runAskpass("mfa-askpass", "Enter MFA code for arn:aws:iam::123456789012:mfa/user: ")
+	if err != nil {
+		t.Fatalf("runAskpass failed: %v", err)
+	}
+	if code != "123456" {
+		t.Errorf("Expected code '123456', got '%s'", code)
+	}
+
+	if _, err := runAskpass("missing-askpass", "prompt"); err == nil {
+		t.Error("Expected an error from a failing askpass program")
+	}
+}
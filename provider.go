@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// credentialProvider hands out the credentials for opts and assumes the role
+// again when they get within the refresh window of expiring
+type credentialProvider struct {
+	opts *roleOptions
+	// status receives the progress of the first assumption and one line per refresh
+	status io.Writer
+
+	mu      sync.Mutex
+	current *Credentials
+	// obtained is when current was obtained, to cap the refresh window of short sessions
+	obtained time.Time
+}
+
+// newCredentialProvider returns a provider for opts that reports to status
+func newCredentialProvider(opts *roleOptions, status io.Writer) *credentialProvider {
+	copied := *opts
+	return &credentialProvider{opts: &copied, status: status}
+}
+
+// Get returns valid credentials, refreshing them if needed
+func (p *credentialProvider) Get() (*Credentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.current != nil && time.Until(p.current.Expiration) > cappedRefreshWindow(p.opts.RefreshWindow, p.obtained, p.current.Expiration) {
+		return p.current, nil
+	}
+
+	// Only the first assumption is reported in full, refreshes would drown other output
+	status := p.status
+	if p.current != nil {
+		status = io.Discard
+	}
+	credentials, err := obtainCredentials(p.opts, status)
+	if err != nil {
+		return nil, err
+	}
+	if p.current != nil {
+		fmt.Fprintf(p.status, "Refreshed credentials, valid until %s\n", credentials.Expiration.Local().Format("2006-01-02 15:04:05 MST"))
+	}
+	// An MFA code is only valid once; refreshes use a cached MFA session or prompt
+	p.opts.MFAToken = ""
+	p.current = credentials
+	p.obtained = time.Now()
+	return credentials, nil
+}
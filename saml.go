@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// samlRoleAttribute is the SAML attribute listing the roles a user may assume
+const samlRoleAttribute = "https://aws.amazon.com/SAML/Attributes/Role"
+
+// samlRole is a role and identity provider pair offered by a SAML assertion
+type samlRole struct {
+	RoleArn      string
+	PrincipalArn string
+}
+
+// usesSAML reports whether opts assume the first role with a SAML assertion
+func usesSAML(opts *roleOptions) bool {
+	return opts.SAMLAssertionFile != "" || opts.SAMLAssertionEnv != ""
+}
+
+// samlAssertionSource describes where the SAML assertion comes from
+func samlAssertionSource(opts *roleOptions) string {
+	switch {
+	case opts.SAMLAssertionEnv != "":
+		return "$" + opts.SAMLAssertionEnv
+	case opts.SAMLAssertionFile == "-":
+		return "stdin"
+	default:
+		return opts.SAMLAssertionFile
+	}
+}
+
+// readSAMLAssertion reads the base64 encoded SAML response from
+// --saml-assertion-file (- for stdin) or --saml-assertion-env
+func readSAMLAssertion(opts *roleOptions) (string, error) {
+	var assertion string
+	switch {
+	case opts.SAMLAssertionEnv != "":
+		assertion = os.Getenv(opts.SAMLAssertionEnv)
+	case opts.SAMLAssertionFile == "-":
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", fmt.Errorf("failed to read SAML assertion from stdin: %w", err)
+		}
+		assertion = string(data)
+	default:
+		data, err := os.ReadFile(opts.SAMLAssertionFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read SAML assertion: %w", err)
+		}
+		assertion = string(data)
+	}
+
+	// Browser extensions often copy the assertion with line breaks
+	assertion = strings.Join(strings.Fields(assertion), "")
+	if assertion == "" {
+		return "", fmt.Errorf("SAML assertion from %s is empty", samlAssertionSource(opts))
+	}
+	return assertion, nil
+}
+
+// parseSAMLRoles decodes a base64 SAML response and returns the roles in its
+// Role attribute. Each value is "role ARN,provider ARN" in either order.
+func parseSAMLRoles(assertion string) ([]samlRole, error) {
+	document, err := base64.StdEncoding.DecodeString(assertion)
+	if err != nil {
+		return nil, fmt.Errorf("SAML assertion is not valid base64: %w", err)
+	}
+
+	var roles []samlRole
+	decoder := xml.NewDecoder(bytes.NewReader(document))
+	inRoleAttribute := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("SAML assertion is not valid XML: %w", err)
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			if element.Name.Local == "Attribute" {
+				inRoleAttribute = false
+				for _, attr := range element.Attr {
+					if attr.Name.Local == "Name" && attr.Value == samlRoleAttribute {
+						inRoleAttribute = true
+					}
+				}
+			}
+			if element.Name.Local == "AttributeValue" && inRoleAttribute {
+				var value string
+				if err := decoder.DecodeElement(&value, &element); err != nil {
+					return nil, fmt.Errorf("SAML assertion is not valid XML: %w", err)
+				}
+				role, err := parseSAMLRoleValue(value)
+				if err != nil {
+					return nil, err
+				}
+				roles = append(roles, role)
+			}
+		case xml.EndElement:
+			if element.Name.Local == "Attribute" {
+				inRoleAttribute = false
+			}
+		}
+	}
+
+	if len(roles) == 0 {
+		return nil, fmt.Errorf("SAML assertion contains no AWS roles")
+	}
+	return roles, nil
+}
+
+// parseSAMLRoleValue splits one value of the Role attribute
+func parseSAMLRoleValue(value string) (samlRole, error) {
+	first, second, ok := strings.Cut(strings.TrimSpace(value), ",")
+	first, second = strings.TrimSpace(first), strings.TrimSpace(second)
+	if !ok {
+		return samlRole{}, fmt.Errorf("invalid SAML role %q", value)
+	}
+	switch {
+	case strings.Contains(first, ":role/") && strings.Contains(second, ":saml-provider/"):
+		return samlRole{RoleArn: first, PrincipalArn: second}, nil
+	case strings.Contains(second, ":role/") && strings.Contains(first, ":saml-provider/"):
+		return samlRole{RoleArn: second, PrincipalArn: first}, nil
+	default:
+		return samlRole{}, fmt.Errorf("invalid SAML role %q", value)
+	}
+}
+
+// resolveSAMLRole reads the SAML assertion of opts and returns it with the
+// role to assume: the first --role-arn, or one chosen from the assertion
+func resolveSAMLRole(opts *roleOptions) (string, samlRole, error) {
+	assertion, err := readSAMLAssertion(opts)
+	if err != nil {
+		return "", samlRole{}, err
+	}
+	roles, err := parseSAMLRoles(assertion)
+	if err != nil {
+		return "", samlRole{}, err
+	}
+
+	var roleArn string
+	if len(opts.RoleArns) > 0 {
+		hop, err := parseRoleHop(opts.RoleArns[0])
+		if err != nil {
+			return "", samlRole{}, err
+		}
+		roleArn = hop.RoleArn
+	}
+	role, err := chooseSAMLRole(roles, roleArn)
+	return assertion, role, err
+}
+
+// chooseSAMLRole returns the role of roles named roleArn, or when roleArn is
+// empty the only role or the one the user picks on the terminal
+func chooseSAMLRole(roles []samlRole, roleArn string) (samlRole, error) {
+	if roleArn != "" {
+		for _, role := range roles {
+			if role.RoleArn == roleArn {
+				return role, nil
+			}
+		}
+		return samlRole{}, fmt.Errorf("role %s is not in the SAML assertion, which contains:\n%s", roleArn, formatSAMLRoles(roles))
+	}
+	if len(roles) == 1 {
+		return roles[0], nil
+	}
+
+	answer, err := readLine(formatSAMLRoles(roles) + fmt.Sprintf("Choose a role [1-%d]: ", len(roles)))
+	if errors.Is(err, errNoTerminal) {
+		return samlRole{}, fmt.Errorf("the SAML assertion contains %d roles, choose one with --role-arn:\n%s", len(roles), formatSAMLRoles(roles))
+	}
+	if err != nil {
+		return samlRole{}, err
+	}
+	choice, err := strconv.Atoi(strings.TrimSpace(answer))
+	if err != nil || choice < 1 || choice > len(roles) {
+		return samlRole{}, fmt.Errorf("invalid choice %q", strings.TrimSpace(answer))
+	}
+	return roles[choice-1], nil
+}
+
+// formatSAMLRoles lists roles as a numbered menu
+func formatSAMLRoles(roles []samlRole) string {
+	var b strings.Builder
+	for i, role := range roles {
+		fmt.Fprintf(&b, "  %d) %s (via %s)\n", i+1, role.RoleArn, role.PrincipalArn)
+	}
+	return b.String()
+}
+
+// assumeRoleWithSAML assumes role with the SAML assertion. Like web identity,
+// only the settings AssumeRoleWithSAML accepts are taken from input.
+func assumeRoleWithSAML(client stsAPI, assertion string, role samlRole, input *assumeRoleInput) (*assumeRoleResult, error) {
+	result, err := client.AssumeRoleWithSAML(&samlInput{
+		RoleArn:         role.RoleArn,
+		PrincipalArn:    role.PrincipalArn,
+		SAMLAssertion:   assertion,
+		DurationSeconds: input.DurationSeconds,
+		Policy:          input.Policy,
+		PolicyArns:      input.PolicyArns,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Credentials.AccessKeyId == "" || result.Credentials.SecretAccessKey == "" || result.Credentials.SessionToken == "" {
+		return nil, fmt.Errorf("failed to get valid credentials from AWS response")
+	}
+	return result, nil
+}
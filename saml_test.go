@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testSAMLAssertion returns a base64 SAML response offering roles
+func testSAMLAssertion(roles ...string) string {
+	var values strings.Builder
+	for _, role := range roles {
+		fmt.Fprintf(&values, `<saml2:AttributeValue xsi:type="xs:string">%s</saml2:AttributeValue>`, role)
+	}
+	response := `<?xml version="1.0" encoding="UTF-8"?>
+<saml2p:Response xmlns:saml2p="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <saml2:Assertion xmlns:saml2="urn:oasis:names:tc:SAML:2.0:assertion">
+    <saml2:AttributeStatement>
+      <saml2:Attribute Name="https://aws.amazon.com/SAML/Attributes/RoleSessionName">
+        <saml2:AttributeValue>alice@example.com</saml2:AttributeValue>
+      </saml2:Attribute>
+      <saml2:Attribute Name="https://aws.amazon.com/SAML/Attributes/Role">` + values.String() + `</saml2:Attribute>
+    </saml2:AttributeStatement>
+  </saml2:Assertion>
+</saml2p:Response>`
+	return base64.StdEncoding.EncodeToString([]byte(response))
+}
+
+// Test extracting the role and provider pairs from a SAML assertion
+func TestParseSAMLRoles(t *testing.T) {
+	assertion := testSAMLAssertion(
+		"arn:aws:iam::111111111111:role/Developer,arn:aws:iam::111111111111:saml-provider/Okta",
+		"arn:aws:iam::222222222222:saml-provider/Okta, arn:aws:iam::222222222222:role/ReadOnly",
+	)
+	roles, err := parseSAMLRoles(assertion)
+	if err != nil {
+		t.Fatalf("parseSAMLRoles failed: %v", err)
+	}
+	expected := []samlRole{
+		{RoleArn: "arn:aws:iam::111111111111:role/Developer", PrincipalArn: "arn:aws:iam::111111111111:saml-provider/Okta"},
+		{RoleArn: "arn:aws:iam::222222222222:role/ReadOnly", PrincipalArn: "arn:aws:iam::222222222222:saml-provider/Okta"},
+	}
+	if fmt.Sprint(roles) != fmt.Sprint(expected) {
+		t.Errorf("parseSAMLRoles = %v, expected %v", roles, expected)
+	}
+
+	for _, invalid := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("<Response/>")), testSAMLAssertion("garbage")} {
+		if _, err := parseSAMLRoles(invalid); err == nil {
+			t.Errorf("Expected an error for assertion %q", invalid)
+		}
+	}
+}
+
+// Test picking a role from the assertion
+func TestChooseSAMLRole(t *testing.T) {
+	roles := []samlRole{
+		{RoleArn: "arn:aws:iam::111111111111:role/Developer", PrincipalArn: "arn:aws:iam::111111111111:saml-provider/Okta"},
+		{RoleArn: "arn:aws:iam::222222222222:role/ReadOnly", PrincipalArn: "arn:aws:iam::222222222222:saml-provider/Okta"},
+	}
+
+	originalReadLine := readLine
+	defer func() { readLine = originalReadLine }()
+	readLine = func(prompt string) (string, error) { return "2", nil }
+
+	role, err := chooseSAMLRole(roles, "")
+	if err != nil || role != roles[1] {
+		t.Errorf("Expected the picked role, got %v, %v", role, err)
+	}
+	role, err = chooseSAMLRole(roles, "arn:aws:iam::111111111111:role/Developer")
+	if err != nil || role != roles[0] {
+		t.Errorf("Expected the role given with --role-arn, got %v, %v", role, err)
+	}
+	if _, err := chooseSAMLRole(roles, "arn:aws:iam::333333333333:role/Admin"); err == nil {
+		t.Error("Expected an error for a role missing from the assertion")
+	}
+
+	readLine = func(prompt string) (string, error) { return "", errNoTerminal }
+	if _, err := chooseSAMLRole(roles, ""); err == nil || !strings.Contains(err.Error(), "--role-arn") {
+		t.Errorf("Expected an error asking for --role-arn, got %v", err)
+	}
+	if role, err := chooseSAMLRole(roles[:1], ""); err != nil || role != roles[0] {
+		t.Errorf("Expected the only role, got %v, %v", role, err)
+	}
+}
+
+// Test assuming the role from a SAML assertion without a source profile
+func TestObtainCredentialsSAML(t *testing.T) {
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		calls = append(calls, fmt.Sprintf("%s %s %s %t %q", r.PostForm.Get("Action"), r.PostForm.Get("RoleArn"),
+			r.PostForm.Get("PrincipalArn"), r.PostForm.Get("SAMLAssertion") != "", r.Header.Get("Authorization")))
+		fmt.Fprint(w, `<AssumeRoleWithSAMLResponse><AssumeRoleWithSAMLResult><Credentials>
+			<AccessKeyId>ASIASAML</AccessKeyId><SecretAccessKey>secret</SecretAccessKey>
+			<SessionToken>token</SessionToken><Expiration>2030-01-01T00:00:00Z</Expiration>
+			</Credentials></AssumeRoleWithSAMLResult></AssumeRoleWithSAMLResponse>`)
+	}))
+	defer server.Close()
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+	t.Setenv("TEST_SAML_RESPONSE", testSAMLAssertion("arn:aws:iam::111111111111:role/Developer,arn:aws:iam::111111111111:saml-provider/Okta"))
+
+	opts := &roleOptions{SAMLAssertionEnv: "TEST_SAML_RESPONSE", Duration: 3600, NoCache: true}
+	creds, err := obtainCredentials(opts, io.Discard)
+	if err != nil {
+		t.Fatalf("obtainCredentials failed: %v", err)
+	}
+	if creds.AccessKeyId != "ASIASAML" {
+		t.Errorf("Expected AccessKeyId 'ASIASAML', got '%s'", creds.AccessKeyId)
+	}
+	expected := `AssumeRoleWithSAML arn:aws:iam::111111111111:role/Developer arn:aws:iam::111111111111:saml-provider/Okta true ""`
+	if len(calls) != 1 || calls[0] != expected {
+		t.Errorf("Unexpected calls %v, expected %s", calls, expected)
+	}
+}
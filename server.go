@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// defaultServeAddr listens on a free loopback port
+const defaultServeAddr = "127.0.0.1:0"
+
+// ecsCredentials is the response of the ECS container credentials endpoint
+type ecsCredentials struct {
+	AccessKeyId     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+	Expiration      string `json:"Expiration"`
+}
+
+// credentialServer serves credentials from a provider with the ECS container
+// credentials protocol, which every AWS SDK and the aws CLI support
+type credentialServer struct {
+	provider *credentialProvider
+	token    string
+	listener net.Listener
+	server   *http.Server
+}
+
+// startCredentialServer starts serving provider on the loopback address addr.
+// Requests must carry a random token in the Authorization header.
+func startCredentialServer(provider *credentialProvider, addr string) (*credentialServer, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address %q: %w", addr, err)
+	}
+	// The SDKs refuse full URIs on other hosts, and credentials must not leave the machine
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("listen address %q is not a loopback address", addr)
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	s := &credentialServer{provider: provider, token: token, listener: listener}
+	s.server = &http.Server{Handler: s, ReadHeaderTimeout: 10 * time.Second}
+	go s.server.Serve(listener)
+	return s, nil
+}
+
+// randomToken returns a random bearer token
+func randomToken() (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return hex.EncodeToString(data), nil
+}
+
+// URL returns the value for AWS_CONTAINER_CREDENTIALS_FULL_URI
+func (s *credentialServer) URL() string {
+	return "http://" + s.listener.Addr().String() + "/"
+}
+
+// Env returns the variables that point AWS SDKs at the server
+func (s *credentialServer) Env() []string {
+	return []string{
+		"AWS_CONTAINER_CREDENTIALS_FULL_URI=" + s.URL(),
+		"AWS_CONTAINER_AUTHORIZATION_TOKEN=" + s.token,
+	}
+}
+
+// Close stops the server
+func (s *credentialServer) Close() error {
+	return s.server.Close()
+}
+
+// ServeHTTP answers credential requests
+func (s *credentialServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(s.token)) != 1 {
+		http.Error(w, "invalid authorization token", http.StatusUnauthorized)
+		return
+	}
+
+	credentials, err := s.provider.Get()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error refreshing credentials: %v\n", err)
+		http.Error(w, "failed to get credentials", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ecsCredentials{
+		AccessKeyId:     credentials.AccessKeyId,
+		SecretAccessKey: credentials.SecretAccessKey,
+		Token:           credentials.SessionToken,
+		Expiration:      credentials.Expiration.UTC().Format(time.RFC3339),
+	})
+}
+
+// localServer is a running credentials endpoint
+type localServer interface {
+	URL() string
+	Env() []string
+	Close() error
+}
+
+// serveCredentials assumes the role described by opts and serves its
+// credentials on addr until interrupted, printing the variables to export.
+// With imds it emulates the EC2 instance metadata service instead of the
+// ECS container endpoint.
+func serveCredentials(opts *roleOptions, addr string, imds bool) error {
+	provider := newCredentialProvider(opts, os.Stderr)
+
+	// Assume the role up front so MFA prompts and errors happen now
+	if _, err := provider.Get(); err != nil {
+		return err
+	}
+
+	var server localServer
+	if imds {
+		imdsServer, err := startIMDSServer(provider, imdsRoleName(opts), credentialRegion(opts), addr)
+		if err != nil {
+			return err
+		}
+		if ip := imdsServer.listener.Addr().(*net.TCPAddr).IP; !ip.IsLoopback() {
+			fmt.Fprintf(os.Stderr, "Warning: %s is not a loopback address, anything that can reach it can get the credentials\n", addr)
+		}
+		server = imdsServer
+	} else {
+		credentialServer, err := startCredentialServer(provider, addr)
+		if err != nil {
+			return err
+		}
+		server = credentialServer
+	}
+	defer server.Close()
+
+	for _, kv := range server.Env() {
+		fmt.Printf("export %s\n", kv)
+	}
+	fmt.Fprintf(os.Stderr, "Serving credentials on %s, press Ctrl-C to stop\n", server.URL())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	<-signals
+	return nil
+}
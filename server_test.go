@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that the provider assumes the role again only when the credentials are about to expire
+func TestCredentialProviderRefresh(t *testing.T) {
+	calls := 0
+	expiration := time.Now().Add(2 * time.Minute)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprintf(w, `<AssumeRoleResponse><AssumeRoleResult><Credentials>
+			<AccessKeyId>ASIA%d</AccessKeyId><SecretAccessKey>secret</SecretAccessKey>
+			<SessionToken>token</SessionToken><Expiration>%s</Expiration>
+			</Credentials></AssumeRoleResult></AssumeRoleResponse>`, calls, expiration.UTC().Format(time.RFC3339))
+	}))
+	defer server.Close()
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	opts := &roleOptions{
+		RoleArns:      []string{"arn:aws:iam::123456789012:role/TestRole"},
+		Duration:      3600,
+		NoCache:       true,
+		RefreshWindow: time.Minute,
+	}
+	var status bytes.Buffer
+	provider := newCredentialProvider(opts, &status)
+
+	// Valid for longer than the refresh window, so the second call is served from memory
+	for i := 0; i < 2; i++ {
+		credentials, err := provider.Get()
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if credentials.AccessKeyId != "ASIA1" {
+			t.Errorf("Expected AccessKeyId 'ASIA1', got '%s'", credentials.AccessKeyId)
+		}
+	}
+
+	// Inside the refresh window the role is assumed again
+	provider.opts.RefreshWindow = 5 * time.Minute
+	provider.obtained = time.Now().Add(-time.Hour)
+	credentials, err := provider.Get()
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if credentials.AccessKeyId != "ASIA2" || calls != 2 {
+		t.Errorf("Expected a refresh to ASIA2, got '%s' after %d calls", credentials.AccessKeyId, calls)
+	}
+	if !strings.Contains(status.String(), "Refreshed credentials") {
+		t.Errorf("Expected the refresh to be reported, got:\n%s", status.String())
+	}
+}
+
+// Test that sessions no longer than the refresh window are still reused
+func TestCredentialProviderShortSession(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprintf(w, `<AssumeRoleResponse><AssumeRoleResult><Credentials>
+			<AccessKeyId>ASIA%d</AccessKeyId><SecretAccessKey>secret</SecretAccessKey>
+			<SessionToken>token</SessionToken><Expiration>%s</Expiration>
+			</Credentials></AssumeRoleResult></AssumeRoleResponse>`, calls, time.Now().Add(15*time.Minute).UTC().Format(time.RFC3339))
+	}))
+	defer server.Close()
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	opts := &roleOptions{
+		RoleArns:      []string{"arn:aws:iam::123456789012:role/TestRole"},
+		Duration:      900,
+		NoCache:       true,
+		RefreshWindow: defaultRefreshWindow,
+	}
+	provider := newCredentialProvider(opts, &bytes.Buffer{})
+	for i := 0; i < 3; i++ {
+		if _, err := provider.Get(); err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected the role to be assumed once, got %d calls", calls)
+	}
+}
+
+// Test that the server answers ECS credential requests with the right token only
+func TestCredentialServer(t *testing.T) {
+	provider := newCredentialProvider(&roleOptions{}, &bytes.Buffer{})
+	provider.current = &Credentials{
+		AccessKeyId:     "ASIASERVED",
+		SecretAccessKey: "secret",
+		SessionToken:    "token",
+		Expiration:      time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	server, err := startCredentialServer(provider, defaultServeAddr)
+	if err != nil {
+		t.Fatalf("startCredentialServer failed: %v", err)
+	}
+	defer server.Close()
+
+	testCases := []struct {
+		name           string
+		method         string
+		token          string
+		expectedStatus int
+	}{
+		{"valid token", http.MethodGet, server.token, http.StatusOK},
+		{"missing token", http.MethodGet, "", http.StatusUnauthorized},
+		{"wrong token", http.MethodGet, "guess", http.StatusUnauthorized},
+		{"wrong method", http.MethodPost, server.token, http.StatusMethodNotAllowed},
+	}
+
+	for _, tc := range testCases {
+		req, _ := http.NewRequest(tc.method, server.URL(), nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", tc.token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", tc.name, err)
+		}
+		if resp.StatusCode != tc.expectedStatus {
+			t.Errorf("%s: expected status %d, got %d", tc.name, tc.expectedStatus, resp.StatusCode)
+		}
+		if resp.StatusCode == http.StatusOK {
+			var body ecsCredentials
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Errorf("%s: invalid response: %v", tc.name, err)
+			}
+			expected := ecsCredentials{AccessKeyId: "ASIASERVED", SecretAccessKey: "secret", Token: "token", Expiration: "2030-01-01T00:00:00Z"}
+			if body != expected {
+				t.Errorf("%s: got %+v, expected %+v", tc.name, body, expected)
+			}
+		}
+		resp.Body.Close()
+	}
+}
+
+// Test that the server refuses to listen beyond the loopback interface
+func TestStartCredentialServerAddress(t *testing.T) {
+	testCases := []struct {
+		addr        string
+		expectedErr bool
+	}{
+		{"127.0.0.1:0", false},
+		{"localhost:0", false},
+		{"0.0.0.0:0", true},
+		{":0", true},
+		{"192.168.1.10:9911", true},
+		{"127.0.0.1", true},
+	}
+
+	for _, tc := range testCases {
+		server, err := startCredentialServer(newCredentialProvider(&roleOptions{}, &bytes.Buffer{}), tc.addr)
+		if (err != nil) != tc.expectedErr {
+			t.Errorf("startCredentialServer(%q) error = %v, expectedErr %v", tc.addr, err, tc.expectedErr)
+		}
+		if server != nil {
+			server.Close()
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSessionNameLength is the longest role session name STS accepts
+const maxSessionNameLength = 64
+
+// parseSessionTags parses repeated key=value tag flags
+func parseSessionTags(specs []string) ([]sessionTag, error) {
+	var tags []sessionTag
+	for _, spec := range specs {
+		key, value, ok := strings.Cut(spec, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid tag %q: expected key=value", spec)
+		}
+		tags = append(tags, sessionTag{Key: key, Value: value})
+	}
+	return tags, nil
+}
+
+// sessionNameNeedsIdentity reports whether template refers to the caller
+func sessionNameNeedsIdentity(template string) bool {
+	return strings.Contains(template, "{username}") || strings.Contains(template, "{account}")
+}
+
+// expandSessionName fills in the placeholders of a --session-name template:
+// {username} and {account} from identity (which may be nil if neither is
+// used) and {timestamp} with the current Unix time
+func expandSessionName(template string, identity *callerIdentity) string {
+	var username, account string
+	if identity != nil {
+		username = callerName(identity.Arn)
+		account = identity.Account
+	}
+	name := strings.NewReplacer(
+		"{username}", username,
+		"{account}", account,
+		"{timestamp}", strconv.FormatInt(time.Now().Unix(), 10),
+	).Replace(template)
+	return sanitizeSessionName(name)
+}
+
+// callerName returns the user behind a caller ARN: the IAM user or federated
+// user name, or the session name of an assumed role (often an email address)
+func callerName(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 {
+		return ""
+	}
+	resource := parts[5]
+	return resource[strings.LastIndex(resource, "/")+1:]
+}
+
+// sanitizeSessionName replaces the characters STS rejects in session names
+// and shortens the name to the maximum length
+func sanitizeSessionName(name string) string {
+	sanitized := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("_+=,.@-", r) {
+			return r
+		}
+		return '-'
+	}, name)
+	if len(sanitized) > maxSessionNameLength {
+		sanitized = sanitized[:maxSessionNameLength]
+	}
+	return sanitized
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test parsing of --tag values
+func TestParseSessionTags(t *testing.T) {
+	tags, err := parseSessionTags([]string{"team=platform", "ticket=OPS-1=2", "empty="})
+	if err != nil {
+		t.Fatalf("parseSessionTags failed: %v", err)
+	}
+	expected := []sessionTag{{"team", "platform"}, {"ticket", "OPS-1=2"}, {"empty", ""}}
+	if fmt.Sprint(tags) != fmt.Sprint(expected) {
+		t.Errorf("parseSessionTags = %v, expected %v", tags, expected)
+	}
+
+	for _, spec := range []string{"team", "=platform"} {
+		if _, err := parseSessionTags([]string{spec}); err == nil {
+			t.Errorf("Expected an error for tag %q", spec)
+		}
+	}
+}
+
+// Test expansion of --session-name templates
+func TestExpandSessionName(t *testing.T) {
+	testCases := []struct {
+		template string
+		arn      string
+		expected string
+	}{
+		{"{username}", "arn:aws:iam::123456789012:user/engineering/alice", "alice"},
+		{"{username}@{account}", "arn:aws:sts::123456789012:assumed-role/SSOAdmin/alice@example.com", "alice@example.com@123456789012"},
+		{"ci run #42", "", "ci-run--42"},
+		{strings.Repeat("a", 70), "", strings.Repeat("a", 64)},
+	}
+	for _, tc := range testCases {
+		var identity *callerIdentity
+		if tc.arn != "" {
+			identity = &callerIdentity{Arn: tc.arn, Account: "123456789012"}
+		}
+		if name := expandSessionName(tc.template, identity); name != tc.expected {
+			t.Errorf("expandSessionName(%q) = %q, expected %q", tc.template, name, tc.expected)
+		}
+	}
+}
+
+// Test that session names, source identity and tags reach AssumeRole
+func TestObtainCredentialsSessionAttribution(t *testing.T) {
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("Action") == "GetCallerIdentity" {
+			fmt.Fprint(w, `<GetCallerIdentityResponse><GetCallerIdentityResult>
+				<Arn>arn:aws:iam::123456789012:user/alice</Arn><UserId>AIDAMOCK</UserId><Account>123456789012</Account>
+				</GetCallerIdentityResult></GetCallerIdentityResponse>`)
+			return
+		}
+		calls = append(calls, strings.Join([]string{
+			r.PostForm.Get("RoleSessionName"),
+			r.PostForm.Get("SourceIdentity"),
+			r.PostForm.Get("Tags.member.1.Key") + "=" + r.PostForm.Get("Tags.member.1.Value"),
+			r.PostForm.Get("Tags.member.2.Key") + "=" + r.PostForm.Get("Tags.member.2.Value"),
+			r.PostForm.Get("TransitiveTagKeys.member.1"),
+		}, " "))
+		fmt.Fprint(w, mockAssumeRoleResponse)
+	}))
+	defer server.Close()
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	opts := &roleOptions{
+		RoleArns:       []string{"arn:aws:iam::111111111111:role/hub", "arn:aws:iam::222222222222:role/workload"},
+		SessionName:    "{username}-cli",
+		SourceIdentity: "{username}",
+		Tags:           []string{"team=platform"},
+		TransitiveTags: []string{"owner=alice"},
+		Duration:       3600,
+		NoCache:        true,
+	}
+	if _, err := obtainCredentials(opts, io.Discard); err != nil {
+		t.Fatalf("obtainCredentials failed: %v", err)
+	}
+
+	expected := []string{
+		"alice-cli alice team=platform owner=alice owner",
+		"alice-cli  team=platform = ",
+	}
+	if strings.Join(calls, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected AssumeRole calls:\n%s\nexpected:\n%s", strings.Join(calls, "\n"), strings.Join(expected, "\n"))
+	}
+}
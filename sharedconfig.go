@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// sharedCredentialsFile returns the path of the shared credentials file
+func sharedCredentialsFile() string {
+	if path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE"); path != "" {
+		return path
+	}
+	return filepath.Join(awsConfigDir(), "credentials")
+}
+
+// sharedConfigFile returns the path of the shared config file
+func sharedConfigFile() string {
+	if path := os.Getenv("AWS_CONFIG_FILE"); path != "" {
+		return path
+	}
+	return filepath.Join(awsConfigDir(), "config")
+}
+
+// awsConfigDir returns the ~/.aws directory
+func awsConfigDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".aws"
+	}
+	return filepath.Join(home, ".aws")
+}
+
+// configSectionName returns the config file section name for a profile
+func configSectionName(profile string) string {
+	if profile == "default" {
+		return "default"
+	}
+	return "profile " + profile
+}
+
+// loadProfile merges the credentials and config file settings for profile.
+// Values from the credentials file take precedence, like the AWS CLI.
+func loadProfile(profile string) (map[string]string, bool, error) {
+	merged := make(map[string]string)
+	found := false
+
+	config, err := loadINIFile(sharedConfigFile())
+	if err != nil {
+		return nil, false, err
+	}
+	names := []string{configSectionName(profile)}
+	if profile == "default" {
+		// The CLI also accepts [profile default] in the config file
+		names = append(names, "profile default")
+	}
+	for _, name := range names {
+		if config.hasSection(name) {
+			found = true
+			for key, value := range config.values(name) {
+				merged[key] = value
+			}
+		}
+	}
+
+	credentials, err := loadINIFile(sharedCredentialsFile())
+	if err != nil {
+		return nil, false, err
+	}
+	if credentials.hasSection(profile) {
+		found = true
+		for key, value := range credentials.values(profile) {
+			merged[key] = value
+		}
+	}
+
+	return merged, found, nil
+}
+
+// loadSSOSession returns the settings of an [sso-session name] section of the config file
+func loadSSOSession(name string) (map[string]string, error) {
+	config, err := loadINIFile(sharedConfigFile())
+	if err != nil {
+		return nil, err
+	}
+	section := "sso-session " + name
+	if !config.hasSection(section) {
+		return nil, fmt.Errorf("sso-session %q not found in %s", name, sharedConfigFile())
+	}
+	return config.values(section), nil
+}
+
+// sectionWrite describes what writeProfile did to the profile's section of
+// one file, so cleanup can undo exactly that
+type sectionWrite struct {
+	// Created marks a section that didn't exist before, and Separated one
+	// that got a blank line added before it
+	Created   bool `json:"Created,omitempty"`
+	Separated bool `json:"Separated,omitempty"`
+	// Previous holds the lines of keys that were replaced in a section
+	// that existed before
+	Previous map[string]string `json:"Previous,omitempty"`
+}
+
+// profileWrite describes what writeProfile did to each shared file
+type profileWrite struct {
+	Credentials sectionWrite
+	Config      sectionWrite
+}
+
+// writeProfile atomically stores credentialValues in the credentials file and
+// configValues in the config file for profile. Each file is written at most once.
+func writeProfile(profile string, credentialValues, configValues [][2]string) (profileWrite, error) {
+	var write profileWrite
+	var err error
+	if len(credentialValues) > 0 {
+		if write.Credentials, err = writeSection(sharedCredentialsFile(), profile, credentialValues); err != nil {
+			return write, err
+		}
+	}
+	if len(configValues) > 0 {
+		if write.Config, err = writeSection(sharedConfigFile(), configSectionName(profile), configValues); err != nil {
+			return write, err
+		}
+	}
+	return write, nil
+}
+
+// writeSection stores values in section name of the INI file at path
+func writeSection(path, name string, values [][2]string) (sectionWrite, error) {
+	var write sectionWrite
+	file, err := loadINIFile(path)
+	if err != nil {
+		return write, err
+	}
+
+	write.Created = !file.hasSection(name)
+	write.Separated = write.Created && file.needsSeparator()
+	for _, kv := range values {
+		if raw, ok := file.line(name, kv[0]); ok {
+			if write.Previous == nil {
+				write.Previous = make(map[string]string)
+			}
+			write.Previous[kv[0]] = raw
+		}
+		file.set(name, kv[0], kv[1])
+	}
+	return write, file.save(path)
+}
+
+// effectiveProfile returns the profile the AWS CLI would use when none is given
+func effectiveProfile(profile string) string {
+	if profile != "" {
+		return profile
+	}
+	if env := os.Getenv("AWS_PROFILE"); env != "" {
+		return env
+	}
+	if env := os.Getenv("AWS_DEFAULT_PROFILE"); env != "" {
+		return env
+	}
+	return "default"
+}
+
+// resolveSourceCredentials finds the long-lived credentials for profile the same
+// way the AWS CLI does: an explicit profile wins, then the AWS_ACCESS_KEY_ID
+// environment variables, then AWS_PROFILE and finally the default profile.
+func resolveSourceCredentials(profile string) (*Credentials, error) {
+	if profile == "" {
+		accessKey := os.Getenv("AWS_ACCESS_KEY_ID")
+		secretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+		if accessKey != "" && secretKey != "" {
+			return &Credentials{
+				AccessKeyId:     accessKey,
+				SecretAccessKey: secretKey,
+				SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+			}, nil
+		}
+	}
+
+	name := effectiveProfile(profile)
+	settings, found, err := loadProfile(name)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("profile %q not found in %s or %s", name, sharedCredentialsFile(), sharedConfigFile())
+	}
+
+	if settings["aws_access_key_id"] == "" || settings["aws_secret_access_key"] == "" {
+		for _, key := range []string{"role_arn", "credential_process", "sso_start_url", "sso_session"} {
+			if settings[key] != "" {
+				return nil, fmt.Errorf("profile %q uses %s, which the native backend does not support; use --backend cli", name, key)
+			}
+		}
+		return nil, fmt.Errorf("profile %q has no aws_access_key_id and aws_secret_access_key", name)
+	}
+
+	return &Credentials{
+		AccessKeyId:     settings["aws_access_key_id"],
+		SecretAccessKey: settings["aws_secret_access_key"],
+		SessionToken:    settings["aws_session_token"],
+	}, nil
+}
+
+// resolveRegion returns the region for profile from the environment or config file
+func resolveRegion(profile string) string {
+	if env := os.Getenv("AWS_REGION"); env != "" {
+		return env
+	}
+	if env := os.Getenv("AWS_DEFAULT_REGION"); env != "" {
+		return env
+	}
+	settings, _, err := loadProfile(effectiveProfile(profile))
+	if err != nil {
+		return ""
+	}
+	return settings["region"]
+}
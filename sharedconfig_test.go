@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestFile writes content to name in a temporary directory and returns its path
+func writeTestFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+	return path
+}
+
+// Test resolveSourceCredentials with environment variables and shared files
+func TestResolveSourceCredentials(t *testing.T) {
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", writeTestFile(t, "credentials", `
+# user credentials
+[default]
+aws_access_key_id = AKIDDEFAULT
+aws_secret_access_key = defaultsecret
+
+[source-profile]
+aws_access_key_id=AKIDSOURCE
+aws_secret_access_key=sourcesecret
+aws_session_token=sourcetoken
+`))
+	t.Setenv("AWS_CONFIG_FILE", writeTestFile(t, "config", `
+[default]
+region = us-east-1
+
+[profile config-only]
+aws_access_key_id = AKIDCONFIG
+aws_secret_access_key = configsecret
+region = eu-west-1
+
+[profile assumed]
+role_arn = arn:aws:iam::123456789012:role/TestRole
+source_profile = default
+`))
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_DEFAULT_PROFILE", "")
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+
+	testCases := []struct {
+		name              string
+		profile           string
+		env               map[string]string
+		expectedAccessKey string
+		expectedToken     string
+		expectedErr       string
+	}{
+		{name: "default profile", expectedAccessKey: "AKIDDEFAULT"},
+		{name: "named profile", profile: "source-profile", expectedAccessKey: "AKIDSOURCE", expectedToken: "sourcetoken"},
+		{name: "config file profile", profile: "config-only", expectedAccessKey: "AKIDCONFIG"},
+		{
+			name:              "environment variables",
+			env:               map[string]string{"AWS_ACCESS_KEY_ID": "AKIDENV", "AWS_SECRET_ACCESS_KEY": "envsecret"},
+			expectedAccessKey: "AKIDENV",
+		},
+		{
+			name:              "explicit profile beats environment",
+			profile:           "source-profile",
+			env:               map[string]string{"AWS_ACCESS_KEY_ID": "AKIDENV", "AWS_SECRET_ACCESS_KEY": "envsecret"},
+			expectedAccessKey: "AKIDSOURCE",
+			expectedToken:     "sourcetoken",
+		},
+		{
+			name:              "AWS_PROFILE",
+			env:               map[string]string{"AWS_PROFILE": "config-only"},
+			expectedAccessKey: "AKIDCONFIG",
+		},
+		{name: "missing profile", profile: "missing", expectedErr: "not found"},
+		{name: "unsupported profile", profile: "assumed", expectedErr: "role_arn"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for key, value := range tc.env {
+				t.Setenv(key, value)
+			}
+
+			creds, err := resolveSourceCredentials(tc.profile)
+			if tc.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+					t.Errorf("Expected error containing '%s', got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveSourceCredentials failed: %v", err)
+			}
+			if creds.AccessKeyId != tc.expectedAccessKey {
+				t.Errorf("Expected AccessKeyId '%s', got '%s'", tc.expectedAccessKey, creds.AccessKeyId)
+			}
+			if creds.SessionToken != tc.expectedToken {
+				t.Errorf("Expected SessionToken '%s', got '%s'", tc.expectedToken, creds.SessionToken)
+			}
+		})
+	}
+
+	// The region comes from the config file unless overridden by the environment
+	if region := resolveRegion("config-only"); region != "eu-west-1" {
+		t.Errorf("Expected region 'eu-west-1', got '%s'", region)
+	}
+	t.Setenv("AWS_REGION", "ap-southeast-2")
+	if region := resolveRegion("config-only"); region != "ap-southeast-2" {
+		t.Errorf("Expected region 'ap-southeast-2', got '%s'", region)
+	}
+}
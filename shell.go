@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// shellDialect renders commands that set and clear environment variables in one shell
+type shellDialect struct {
+	set   func(name, value string) (string, error)
+	unset func(name string) string
+}
+
+// shellDialects are the shells generate can write for, by output format
+var shellDialects = map[string]shellDialect{
+	"shell": {
+		set:   func(name, value string) (string, error) { return "export " + name + "=" + posixQuote(value), nil },
+		unset: func(name string) string { return "unset " + name },
+	},
+	"fish": {
+		set:   func(name, value string) (string, error) { return "set -gx " + name + " " + fishQuote(value), nil },
+		unset: func(name string) string { return "set -e " + name },
+	},
+	"csh": {
+		set:   func(name, value string) (string, error) { return "setenv " + name + " " + cshQuote(value), nil },
+		unset: func(name string) string { return "unsetenv " + name },
+	},
+	"powershell": {
+		set:   func(name, value string) (string, error) { return "$env:" + name + " = " + powershellQuote(value), nil },
+		unset: func(name string) string { return "Remove-Item Env:" + name + " -ErrorAction SilentlyContinue" },
+	},
+	"cmd": {
+		set:   cmdSet,
+		unset: func(name string) string { return "set " + name + "=" },
+	},
+	"nushell": {
+		set:   nushellSet,
+		unset: func(name string) string { return "hide-env -i " + name },
+	},
+}
+
+// shellVariables are the variables generate sets, which unset clears
+var shellVariables = []string{
+	"AWS_ACCESS_KEY_ID",
+	"AWS_SECRET_ACCESS_KEY",
+	"AWS_SESSION_TOKEN",
+	"AWS_REGION",
+	"AWS_DEFAULT_REGION",
+	"AWS_CREDENTIAL_EXPIRATION",
+}
+
+// shellDialectNames returns the supported dialects for help and errors
+func shellDialectNames() string {
+	names := make([]string, 0, len(shellDialects))
+	for name := range shellDialects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// resolveShellDialect returns the dialect of an output format. "auto"
+// picks it from $SHELL and falls back to POSIX shells.
+func resolveShellDialect(format string) (shellDialect, bool) {
+	if format == "auto" {
+		format = detectShellDialect(os.Getenv("SHELL"))
+	}
+	dialect, ok := shellDialects[format]
+	return dialect, ok
+}
+
+// detectShellDialect maps the path of a shell to its dialect
+func detectShellDialect(shell string) string {
+	// Accept Windows paths on every OS, e.g. from Git Bash or WSL
+	name := shell[strings.LastIndexAny(shell, `/\`)+1:]
+	name = strings.TrimSuffix(strings.ToLower(name), ".exe")
+	switch name {
+	case "fish":
+		return "fish"
+	case "csh", "tcsh":
+		return "csh"
+	case "pwsh", "powershell":
+		return "powershell"
+	case "cmd":
+		return "cmd"
+	case "nu":
+		return "nushell"
+	default:
+		return "shell"
+	}
+}
+
+// credentialVariables returns the environment variables for credentials and
+// region, in the order generate writes them
+func credentialVariables(credentials *Credentials, region string) [][2]string {
+	variables := [][2]string{
+		{"AWS_ACCESS_KEY_ID", credentials.AccessKeyId},
+		{"AWS_SECRET_ACCESS_KEY", credentials.SecretAccessKey},
+		{"AWS_SESSION_TOKEN", credentials.SessionToken},
+	}
+	if region != "" {
+		variables = append(variables, [2]string{"AWS_REGION", region}, [2]string{"AWS_DEFAULT_REGION", region})
+	}
+	return append(variables, [2]string{"AWS_CREDENTIAL_EXPIRATION", credentials.Expiration.Format(time.RFC3339)})
+}
+
+// writeShellCredentials writes the commands that set credentials and region in dialect
+func writeShellCredentials(w io.Writer, dialect shellDialect, credentials *Credentials, region string) error {
+	variables := credentialVariables(credentials, region)
+
+	// Render everything first so a value that can't be written leaves no partial output
+	lines := make([]string, 0, len(variables))
+	for _, kv := range variables {
+		line, err := dialect.set(kv[0], kv[1])
+		if err != nil {
+			return fmt.Errorf("cannot write %s: %w", kv[0], err)
+		}
+		lines = append(lines, line)
+	}
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+	return nil
+}
+
+// writeShellUnset writes the commands that clear the variables generate sets
+func writeShellUnset(w io.Writer, dialect shellDialect) {
+	for _, name := range shellVariables {
+		fmt.Fprintln(w, dialect.unset(name))
+	}
+}
+
+// isShellSafe reports whether value needs no quoting in any POSIX-like shell
+func isShellSafe(value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, c := range value {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("_@%+=:,./-", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// posixQuote quotes value for sh, bash and zsh
+func posixQuote(value string) string {
+	if isShellSafe(value) {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// fishQuote quotes value for fish, where single quotes allow \\ and \' escapes
+func fishQuote(value string) string {
+	if isShellSafe(value) {
+		return value
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(value) + "'"
+}
+
+// cshQuote quotes value for csh and tcsh, which expand ! even in single quotes
+func cshQuote(value string) string {
+	if isShellSafe(value) {
+		return value
+	}
+	return "'" + strings.NewReplacer("'", `'\''`, "!", `\!`, "\n", "\\\n").Replace(value) + "'"
+}
+
+// powershellQuote quotes value as a PowerShell verbatim string, in which
+// every kind of single quote is escaped by doubling it
+func powershellQuote(value string) string {
+	var b strings.Builder
+	b.WriteByte('\'')
+	for _, c := range value {
+		if strings.ContainsRune("'‘’‚‛", c) {
+			b.WriteRune(c)
+		}
+		b.WriteRune(c)
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+// cmdSet sets a variable in cmd.exe. Quoting the whole assignment keeps
+// & | < > ^ literal, but " % ! and line breaks can't be escaped reliably.
+func cmdSet(name, value string) (string, error) {
+	if strings.ContainsAny(value, "\"%!\r\n") {
+		return "", fmt.Errorf("value contains characters cmd.exe cannot set safely")
+	}
+	return `set "` + name + "=" + value + `"`, nil
+}
+
+// nushellSet sets a variable in nushell with a double-quoted string
+func nushellSet(name, value string) (string, error) {
+	for _, c := range value {
+		if c < ' ' && c != '\n' && c != '\t' || c == 0x7f {
+			return "", fmt.Errorf("value contains control characters")
+		}
+	}
+	quoted := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`).Replace(value)
+	return "$env." + name + ` = "` + quoted + `"`, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test quoting in every dialect for plain values and values with shell syntax
+func TestShellDialects(t *testing.T) {
+	tricky := `it's $HOME "x" \ !`
+	testCases := []struct {
+		dialect  string
+		plain    string
+		tricky   string
+		unset    string
+		rejected bool
+	}{
+		{"shell", "export AWS_SESSION_TOKEN=abc+/=", `export AWS_SESSION_TOKEN='it'\''s $HOME "x" \ !'`, "unset AWS_SESSION_TOKEN", false},
+		{"fish", "set -gx AWS_SESSION_TOKEN abc+/=", `set -gx AWS_SESSION_TOKEN 'it\'s $HOME "x" \\ !'`, "set -e AWS_SESSION_TOKEN", false},
+		{"csh", "setenv AWS_SESSION_TOKEN abc+/=", `setenv AWS_SESSION_TOKEN 'it'\''s $HOME "x" \ \!'`, "unsetenv AWS_SESSION_TOKEN", false},
+		{"powershell", "$env:AWS_SESSION_TOKEN = 'abc+/='", `$env:AWS_SESSION_TOKEN = 'it''s $HOME "x" \ !'`, "Remove-Item Env:AWS_SESSION_TOKEN -ErrorAction SilentlyContinue", false},
+		{"cmd", `set "AWS_SESSION_TOKEN=abc+/="`, "", "set AWS_SESSION_TOKEN=", true},
+		{"nushell", `$env.AWS_SESSION_TOKEN = "abc+/="`, `$env.AWS_SESSION_TOKEN = "it's $HOME \"x\" \\ !"`, "hide-env -i AWS_SESSION_TOKEN", false},
+	}
+
+	for _, tc := range testCases {
+		dialect, ok := resolveShellDialect(tc.dialect)
+		if !ok {
+			t.Fatalf("Unknown dialect %s", tc.dialect)
+		}
+		if line, err := dialect.set("AWS_SESSION_TOKEN", "abc+/="); err != nil || line != tc.plain {
+			t.Errorf("%s: expected %s, got %s (error %v)", tc.dialect, tc.plain, line, err)
+		}
+		line, err := dialect.set("AWS_SESSION_TOKEN", tricky)
+		if tc.rejected {
+			if err == nil {
+				t.Errorf("%s: expected the value to be rejected, got %s", tc.dialect, line)
+			}
+		} else if err != nil || line != tc.tricky {
+			t.Errorf("%s: expected %s, got %s (error %v)", tc.dialect, tc.tricky, line, err)
+		}
+		if line := dialect.unset("AWS_SESSION_TOKEN"); line != tc.unset {
+			t.Errorf("%s: expected %s, got %s", tc.dialect, tc.unset, line)
+		}
+	}
+}
+
+// Test that PowerShell's typographic quotes can't end the string early
+func TestPowershellQuoteSmartQuotes(t *testing.T) {
+	if quoted := powershellQuote("a’b"); quoted != "'a’’b'" {
+		t.Errorf("Expected the typographic quote to be doubled, got %s", quoted)
+	}
+}
+
+// Test picking the dialect from $SHELL
+func TestDetectShellDialect(t *testing.T) {
+	testCases := map[string]string{
+		"/bin/bash":                   "shell",
+		"/usr/bin/zsh":                "shell",
+		"/usr/local/bin/fish":         "fish",
+		"/bin/tcsh":                   "csh",
+		"/usr/bin/pwsh":               "powershell",
+		`C:\Windows\System32\cmd.exe`: "cmd",
+		"/opt/homebrew/bin/nu":        "nushell",
+		"":                            "shell",
+	}
+	for shell, expected := range testCases {
+		if dialect := detectShellDialect(shell); dialect != expected {
+			t.Errorf("detectShellDialect(%q) = %s, expected %s", shell, dialect, expected)
+		}
+	}
+
+	t.Setenv("SHELL", "/usr/bin/fish")
+	dialect, ok := resolveShellDialect("auto")
+	if line, _ := dialect.set("A", "b"); !ok || line != "set -gx A b" {
+		t.Errorf("Expected auto to pick fish, got %s", line)
+	}
+	if _, ok := resolveShellDialect("tcl"); ok {
+		t.Errorf("Expected an unknown dialect to be rejected")
+	}
+}
+
+// Test the full set of variables and that a rejected value writes nothing
+func TestWriteShellCredentials(t *testing.T) {
+	credentials := &Credentials{
+		AccessKeyId:     "ASIAMOCK",
+		SecretAccessKey: "secret",
+		SessionToken:    "token",
+		Expiration:      time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	var out bytes.Buffer
+	if err := writeShellCredentials(&out, shellDialects["fish"], credentials, "eu-west-1"); err != nil {
+		t.Fatalf("writeShellCredentials failed: %v", err)
+	}
+	expected := `set -gx AWS_ACCESS_KEY_ID ASIAMOCK
+set -gx AWS_SECRET_ACCESS_KEY secret
+set -gx AWS_SESSION_TOKEN token
+set -gx AWS_REGION eu-west-1
+set -gx AWS_DEFAULT_REGION eu-west-1
+set -gx AWS_CREDENTIAL_EXPIRATION 2030-01-01T00:00:00Z
+`
+	if out.String() != expected {
+		t.Errorf("Unexpected output:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+
+	out.Reset()
+	credentials.SessionToken = "100%"
+	if err := writeShellCredentials(&out, shellDialects["cmd"], credentials, ""); err == nil || out.Len() > 0 {
+		t.Errorf("Expected an error and no output, got %v and %q", err, out.String())
+	}
+
+	out.Reset()
+	writeShellUnset(&out, shellDialects["shell"])
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != len(shellVariables) || lines[0] != "unset AWS_ACCESS_KEY_ID" {
+		t.Errorf("Unexpected unset output:\n%s", out.String())
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test signRequest against the IAM ListUsers example from the AWS SigV4 documentation
+func TestSignRequestDocumentationExample(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	creds := &Credentials{
+		AccessKeyId:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	signRequest(req, nil, creds, "us-east-1", "iam", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-date, " +
+		"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+	if got := req.Header.Get("Authorization"); got != expected {
+		t.Errorf("Expected Authorization '%s', got '%s'", expected, got)
+	}
+	if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+		t.Errorf("Expected X-Amz-Date '20150830T123600Z', got '%s'", got)
+	}
+}
+
+// Test that session tokens are sent and signed
+func TestSignRequestSessionToken(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "https://sts.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	creds := &Credentials{
+		AccessKeyId:     "ASIAEXAMPLE",
+		SecretAccessKey: "secret",
+		SessionToken:    "token",
+	}
+	signRequest(req, []byte("Action=GetCallerIdentity"), creds, "us-east-1", "sts", time.Now())
+
+	if got := req.Header.Get("X-Amz-Security-Token"); got != "token" {
+		t.Errorf("Expected X-Amz-Security-Token 'token', got '%s'", got)
+	}
+	expected := "SignedHeaders=content-type;host;x-amz-date;x-amz-security-token,"
+	if got := req.Header.Get("Authorization"); !strings.Contains(got, expected) {
+		t.Errorf("Expected Authorization to contain '%s', got '%s'", expected, got)
+	}
+}
+
+// Test sigV4Escape only leaves unreserved characters unescaped
+func TestSigV4Escape(t *testing.T) {
+	testCases := map[string]string{
+		"abc-_.~XYZ09": "abc-_.~XYZ09",
+		"a b":          "a%20b",
+		"a/b+c=d":      "a%2Fb%2Bc%3Dd",
+	}
+	for input, expected := range testCases {
+		if got := sigV4Escape(input); got != expected {
+			t.Errorf("sigV4Escape(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+}
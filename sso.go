@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ssoClientName is how awsomecreds registers itself with IAM Identity Center
+const ssoClientName = "awsomecreds"
+
+// ssoDeviceGrantType is the OAuth grant type of the device authorization flow
+const ssoDeviceGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// ssoSleep is a variable so tests don't wait between token polls
+var ssoSleep = time.Sleep
+
+// ssoConfig describes the IAM Identity Center account and permission set to sign in to
+type ssoConfig struct {
+	StartURL string
+	Region   string
+	// SessionName is the sso-session the settings come from, which names the token cache
+	SessionName string
+	AccountID   string
+	RoleName    string
+}
+
+// ssoToken is an access token cached in the same format as the aws CLI, so
+// a session started with either tool can be used by the other
+type ssoToken struct {
+	StartURL              string    `json:"startUrl"`
+	Region                string    `json:"region"`
+	AccessToken           string    `json:"accessToken"`
+	ExpiresAt             time.Time `json:"expiresAt"`
+	ClientID              string    `json:"clientId,omitempty"`
+	ClientSecret          string    `json:"clientSecret,omitempty"`
+	RegistrationExpiresAt time.Time `json:"registrationExpiresAt,omitempty"`
+}
+
+// ssoAPIError is an error response from the IAM Identity Center APIs
+type ssoAPIError struct {
+	StatusCode  int
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+	Message     string `json:"message"`
+}
+
+func (e *ssoAPIError) Error() string {
+	message := e.Description
+	if message == "" {
+		message = e.Message
+	}
+	return fmt.Sprintf("%s: %s (status %d)", e.Code, message, e.StatusCode)
+}
+
+// resolveSSOConfig returns the IAM Identity Center settings from the --sso-*
+// flags or the sso_* settings of the source profile, or nil if opts don't use SSO
+func resolveSSOConfig(opts *roleOptions) (*ssoConfig, error) {
+	config := &ssoConfig{
+		StartURL:  opts.SSOStartURL,
+		Region:    opts.SSORegion,
+		AccountID: opts.SSOAccountID,
+		RoleName:  opts.SSORoleName,
+	}
+
+	if config.StartURL == "" && opts.SourceProfile != "" {
+		settings, _, err := loadProfile(opts.SourceProfile)
+		if err != nil {
+			return nil, err
+		}
+		if name := settings["sso_session"]; name != "" {
+			session, err := loadSSOSession(name)
+			if err != nil {
+				return nil, err
+			}
+			config.SessionName = name
+			settings["sso_start_url"] = session["sso_start_url"]
+			settings["sso_region"] = session["sso_region"]
+		}
+		if settings["sso_start_url"] == "" {
+			return nil, nil
+		}
+		config.StartURL = settings["sso_start_url"]
+		config.Region = firstNonEmpty(config.Region, settings["sso_region"])
+		config.AccountID = firstNonEmpty(config.AccountID, settings["sso_account_id"])
+		config.RoleName = firstNonEmpty(config.RoleName, settings["sso_role_name"])
+	}
+	if config.StartURL == "" {
+		return nil, nil
+	}
+
+	for _, setting := range []struct{ value, flag string }{
+		{config.Region, "--sso-region"},
+		{config.AccountID, "--sso-account-id"},
+		{config.RoleName, "--sso-role-name"},
+	} {
+		if setting.value == "" {
+			return nil, fmt.Errorf("IAM Identity Center sign-in requires %s", setting.flag)
+		}
+	}
+	return config, nil
+}
+
+// firstNonEmpty returns the first of values that isn't empty
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// obtainSSOCredentials returns the role credentials for config, signing in
+// with the device authorization flow when there is no valid cached token.
+// Sign-in instructions are written to out.
+func obtainSSOCredentials(config *ssoConfig, out io.Writer) (*Credentials, error) {
+	token, err := loadSSOToken(config)
+	if err != nil || token == nil || time.Until(token.ExpiresAt) < time.Minute {
+		if token, err = ssoDeviceLogin(config, token, out); err != nil {
+			return nil, fmt.Errorf("IAM Identity Center sign-in failed: %w", err)
+		}
+		if err := saveSSOToken(config, token); err != nil {
+			fmt.Fprintf(out, "Warning: Failed to cache IAM Identity Center token: %v\n", err)
+		}
+	}
+
+	credentials, err := getSSORoleCredentials(config, token.AccessToken)
+	var apiErr *ssoAPIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized {
+		// The token was revoked or the session ended early, so sign in again next time
+		os.Remove(ssoTokenPath(config))
+		return nil, fmt.Errorf("IAM Identity Center session is no longer valid, run the command again to sign in: %w", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get credentials for account %s role %s: %w", config.AccountID, config.RoleName, err)
+	}
+	return credentials, nil
+}
+
+// ssoDeviceLogin runs the OIDC device authorization flow. The client
+// registration of previous, which may be nil, is reused while it is valid.
+func ssoDeviceLogin(config *ssoConfig, previous *ssoToken, out io.Writer) (*ssoToken, error) {
+	token := &ssoToken{StartURL: config.StartURL, Region: config.Region}
+	if previous != nil && previous.ClientID != "" && time.Until(previous.RegistrationExpiresAt) > time.Hour {
+		token.ClientID = previous.ClientID
+		token.ClientSecret = previous.ClientSecret
+		token.RegistrationExpiresAt = previous.RegistrationExpiresAt
+	} else {
+		var registration struct {
+			ClientID              string `json:"clientId"`
+			ClientSecret          string `json:"clientSecret"`
+			ClientSecretExpiresAt int64  `json:"clientSecretExpiresAt"`
+		}
+		err := ssoOIDCCall(config.Region, "/client/register", map[string]string{
+			"clientName": ssoClientName,
+			"clientType": "public",
+		}, &registration)
+		if err != nil {
+			return nil, fmt.Errorf("failed to register client: %w", err)
+		}
+		token.ClientID = registration.ClientID
+		token.ClientSecret = registration.ClientSecret
+		token.RegistrationExpiresAt = time.Unix(registration.ClientSecretExpiresAt, 0).UTC()
+	}
+
+	var authorization struct {
+		DeviceCode              string `json:"deviceCode"`
+		UserCode                string `json:"userCode"`
+		VerificationURI         string `json:"verificationUri"`
+		VerificationURIComplete string `json:"verificationUriComplete"`
+		ExpiresIn               int    `json:"expiresIn"`
+		Interval                int    `json:"interval"`
+	}
+	err := ssoOIDCCall(config.Region, "/device_authorization", map[string]string{
+		"clientId":     token.ClientID,
+		"clientSecret": token.ClientSecret,
+		"startUrl":     config.StartURL,
+	}, &authorization)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start device authorization: %w", err)
+	}
+
+	fmt.Fprintf(out, "To sign in to IAM Identity Center, open this URL in a browser:\n\n  %s\n\n", authorization.VerificationURIComplete)
+	fmt.Fprintf(out, "and check that it shows the code %s. Waiting for approval...\n", authorization.UserCode)
+
+	// Poll until the user approves, as RFC 8628 describes
+	interval := time.Duration(authorization.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(authorization.ExpiresIn) * time.Second)
+	for {
+		ssoSleep(interval)
+
+		var created struct {
+			AccessToken string `json:"accessToken"`
+			ExpiresIn   int    `json:"expiresIn"`
+		}
+		err := ssoOIDCCall(config.Region, "/token", map[string]string{
+			"clientId":     token.ClientID,
+			"clientSecret": token.ClientSecret,
+			"grantType":    ssoDeviceGrantType,
+			"deviceCode":   authorization.DeviceCode,
+		}, &created)
+		if err == nil {
+			token.AccessToken = created.AccessToken
+			token.ExpiresAt = time.Now().Add(time.Duration(created.ExpiresIn) * time.Second).UTC().Truncate(time.Second)
+			fmt.Fprintf(out, "Signed in to IAM Identity Center\n")
+			return token, nil
+		}
+
+		var apiErr *ssoAPIError
+		if !errors.As(err, &apiErr) {
+			return nil, err
+		}
+		switch apiErr.Code {
+		case "AuthorizationPendingException", "authorization_pending":
+		case "SlowDownException", "slow_down":
+			interval += 5 * time.Second
+		default:
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("the sign-in request expired before it was approved")
+		}
+	}
+}
+
+// getSSORoleCredentials calls the portal GetRoleCredentials API with an access token
+func getSSORoleCredentials(config *ssoConfig, accessToken string) (*Credentials, error) {
+	endpoint := endpointOverride("SSO")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://portal.sso.%s.%s", config.Region, dnsSuffix(config.Region))
+	}
+	query := url.Values{"account_id": {config.AccountID}, "role_name": {config.RoleName}}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(endpoint, "/")+"/federation/credentials?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-amz-sso_bearer_token", accessToken)
+
+	var response struct {
+		RoleCredentials struct {
+			AccessKeyID     string `json:"accessKeyId"`
+			SecretAccessKey string `json:"secretAccessKey"`
+			SessionToken    string `json:"sessionToken"`
+			Expiration      int64  `json:"expiration"`
+		} `json:"roleCredentials"`
+	}
+	if err := ssoDo(req, &response); err != nil {
+		return nil, err
+	}
+
+	role := response.RoleCredentials
+	if role.AccessKeyID == "" || role.SecretAccessKey == "" || role.SessionToken == "" {
+		return nil, fmt.Errorf("failed to get valid credentials from AWS response")
+	}
+	return &Credentials{
+		AccessKeyId:     role.AccessKeyID,
+		SecretAccessKey: role.SecretAccessKey,
+		SessionToken:    role.SessionToken,
+		Expiration:      time.UnixMilli(role.Expiration).UTC(),
+	}, nil
+}
+
+// ssoOIDCCall posts a JSON request to an IAM Identity Center OIDC API path
+func ssoOIDCCall(region, path string, input interface{}, out interface{}) error {
+	endpoint := endpointOverride("SSO_OIDC")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://oidc.%s.%s", region, dnsSuffix(region))
+	}
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(endpoint, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return ssoDo(req, out)
+}
+
+// ssoDo sends req and decodes the JSON response into out
+func ssoDo(req *http.Request, out interface{}) error {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request to %s failed: %w", req.URL.Host, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &ssoAPIError{StatusCode: resp.StatusCode}
+		if json.Unmarshal(data, apiErr) != nil || (apiErr.Code == "" && apiErr.Message == "") {
+			return fmt.Errorf("request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+		}
+		if apiErr.Code == "" {
+			// The portal API names the error in a header instead
+			apiErr.Code = strings.Split(resp.Header.Get("X-Amzn-Errortype"), ":")[0]
+		}
+		return apiErr
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+// ssoTokenPath returns the aws CLI token cache file for config, named after
+// the SHA-1 of the sso-session name or, for legacy settings, the start URL
+func ssoTokenPath(config *ssoConfig) string {
+	sum := sha1.Sum([]byte(firstNonEmpty(config.SessionName, config.StartURL)))
+	return filepath.Join(awsConfigDir(), "sso", "cache", hex.EncodeToString(sum[:])+".json")
+}
+
+// loadSSOToken returns the cached token for config, or nil if there is none
+func loadSSOToken(config *ssoConfig) (*ssoToken, error) {
+	data, err := os.ReadFile(ssoTokenPath(config))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var token ssoToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, err
+	}
+	if token.StartURL != config.StartURL {
+		return nil, nil
+	}
+	return &token, nil
+}
+
+// saveSSOToken caches token for config, readable only by the current user
+func saveSSOToken(config *ssoConfig, token *ssoToken) error {
+	data, err := json.MarshalIndent(token, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(ssoTokenPath(config), data, 0600)
+}
+
+// ssoCacheSource names the SSO identity in credential cache keys
+func ssoCacheSource(config *ssoConfig) string {
+	return "sso:" + config.StartURL + "#" + config.AccountID + "/" + config.RoleName
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newMockSSOServer starts a stand-in for the IAM Identity Center OIDC and
+// portal APIs. The device code is approved after one pending poll.
+func newMockSSOServer(t *testing.T) *[]string {
+	t.Helper()
+	var calls []string
+	polls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.URL.Path)
+		var input map[string]string
+		if r.Method == http.MethodPost {
+			json.NewDecoder(r.Body).Decode(&input)
+		}
+
+		switch r.URL.Path {
+		case "/client/register":
+			fmt.Fprintf(w, `{"clientId":"client-1","clientSecret":"secret-1","clientSecretExpiresAt":%d}`, time.Now().Add(90*24*time.Hour).Unix())
+		case "/device_authorization":
+			if input["clientId"] != "client-1" || input["startUrl"] != "https://example.awsapps.com/start" {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error":"InvalidRequestException","error_description":"bad client or start URL"}`)
+				return
+			}
+			fmt.Fprint(w, `{"deviceCode":"device-1","userCode":"ABCD-EFGH","verificationUri":"https://device.sso.us-east-1.amazonaws.com/",
+				"verificationUriComplete":"https://device.sso.us-east-1.amazonaws.com/?user_code=ABCD-EFGH","expiresIn":600,"interval":1}`)
+		case "/token":
+			polls++
+			if polls == 1 {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error":"authorization_pending"}`)
+				return
+			}
+			if input["grantType"] != ssoDeviceGrantType || input["deviceCode"] != "device-1" {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error":"invalid_grant"}`)
+				return
+			}
+			fmt.Fprint(w, `{"accessToken":"access-1","tokenType":"Bearer","expiresIn":28800}`)
+		case "/federation/credentials":
+			if r.Header.Get("x-amz-sso_bearer_token") != "access-1" {
+				w.Header().Set("X-Amzn-Errortype", "UnauthorizedException:")
+				w.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprint(w, `{"message":"Session token not found or invalid"}`)
+				return
+			}
+			fmt.Fprintf(w, `{"roleCredentials":{"accessKeyId":"ASIASSO","secretAccessKey":"secret","sessionToken":"token","expiration":%d}}`,
+				time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("AWS_ENDPOINT_URL_SSO_OIDC", server.URL)
+	t.Setenv("AWS_ENDPOINT_URL_SSO", server.URL)
+
+	originalSleep := ssoSleep
+	ssoSleep = func(time.Duration) {}
+	t.Cleanup(func() { ssoSleep = originalSleep })
+	return &calls
+}
+
+// Test the device authorization flow and the token cache
+func TestObtainSSOCredentials(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	calls := newMockSSOServer(t)
+	config := &ssoConfig{StartURL: "https://example.awsapps.com/start", Region: "us-east-1", AccountID: "123456789012", RoleName: "ReadOnly"}
+
+	var out bytes.Buffer
+	creds, err := obtainSSOCredentials(config, &out)
+	if err != nil {
+		t.Fatalf("obtainSSOCredentials failed: %v", err)
+	}
+	if creds.AccessKeyId != "ASIASSO" || !creds.Expiration.Equal(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected credentials %+v", creds)
+	}
+	if !strings.Contains(out.String(), "user_code=ABCD-EFGH") {
+		t.Errorf("Expected the verification URL in the output, got:\n%s", out.String())
+	}
+	expected := "/client/register /device_authorization /token /token /federation/credentials"
+	if strings.Join(*calls, " ") != expected {
+		t.Errorf("Unexpected calls %v, expected %s", *calls, expected)
+	}
+
+	// The token is cached where the aws CLI keeps it
+	info, err := os.Stat(ssoTokenPath(config))
+	if err != nil {
+		t.Fatalf("Expected a cached token: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected token cache permissions 0600, got %o", info.Mode().Perm())
+	}
+
+	// A second call reuses the cached token
+	*calls = nil
+	if _, err := obtainSSOCredentials(config, io.Discard); err != nil {
+		t.Fatalf("obtainSSOCredentials with a cached token failed: %v", err)
+	}
+	if strings.Join(*calls, " ") != "/federation/credentials" {
+		t.Errorf("Expected only GetRoleCredentials with a cached token, got %v", *calls)
+	}
+
+	// A revoked token is removed so the next call signs in again
+	token, _ := loadSSOToken(config)
+	token.AccessToken = "revoked"
+	saveSSOToken(config, token)
+	if _, err := obtainSSOCredentials(config, io.Discard); err == nil || !strings.Contains(err.Error(), "no longer valid") {
+		t.Errorf("Expected an invalid session error, got %v", err)
+	}
+	if _, err := os.Stat(ssoTokenPath(config)); !os.IsNotExist(err) {
+		t.Errorf("Expected the revoked token to be removed, got %v", err)
+	}
+}
+
+// Test reading IAM Identity Center settings from flags and profiles
+func TestResolveSSOConfig(t *testing.T) {
+	t.Setenv("AWS_CONFIG_FILE", writeTestFile(t, "config", `
+[profile legacy]
+sso_start_url = https://legacy.awsapps.com/start
+sso_region = eu-west-1
+sso_account_id = 111111111111
+sso_role_name = Admin
+
+[profile modern]
+sso_session = corp
+sso_account_id = 222222222222
+sso_role_name = ReadOnly
+
+[sso-session corp]
+sso_start_url = https://corp.awsapps.com/start
+sso_region = us-east-2
+
+[profile keys]
+region = us-west-2
+`))
+
+	testCases := []struct {
+		name        string
+		opts        roleOptions
+		expected    *ssoConfig
+		expectedErr bool
+	}{
+		{name: "no SSO", opts: roleOptions{SourceProfile: "keys"}},
+		{name: "legacy profile", opts: roleOptions{SourceProfile: "legacy"},
+			expected: &ssoConfig{StartURL: "https://legacy.awsapps.com/start", Region: "eu-west-1", AccountID: "111111111111", RoleName: "Admin"}},
+		{name: "sso-session", opts: roleOptions{SourceProfile: "modern", SSORoleName: "Admin"},
+			expected: &ssoConfig{StartURL: "https://corp.awsapps.com/start", Region: "us-east-2", SessionName: "corp", AccountID: "222222222222", RoleName: "Admin"}},
+		{name: "flags", opts: roleOptions{SSOStartURL: "https://flags.awsapps.com/start", SSORegion: "us-east-1", SSOAccountID: "333333333333", SSORoleName: "Dev"},
+			expected: &ssoConfig{StartURL: "https://flags.awsapps.com/start", Region: "us-east-1", AccountID: "333333333333", RoleName: "Dev"}},
+		{name: "missing account", opts: roleOptions{SSOStartURL: "https://flags.awsapps.com/start", SSORegion: "us-east-1", SSORoleName: "Dev"}, expectedErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config, err := resolveSSOConfig(&tc.opts)
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("resolveSSOConfig error = %v, expectedErr %v", err, tc.expectedErr)
+			}
+			if fmt.Sprint(config) != fmt.Sprint(tc.expected) {
+				t.Errorf("resolveSSOConfig = %+v, expected %+v", config, tc.expected)
+			}
+		})
+	}
+}
+
+// Test using IAM Identity Center credentials directly and as the source of a role chain
+func TestObtainCredentialsSSO(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	newMockSSOServer(t)
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential=ASIASSO/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, mockAssumeRoleResponse)
+	}))
+	defer sts.Close()
+	t.Setenv("AWS_ENDPOINT_URL_STS", sts.URL)
+
+	opts := &roleOptions{
+		SSOStartURL:  "https://example.awsapps.com/start",
+		SSORegion:    "us-east-1",
+		SSOAccountID: "123456789012",
+		SSORoleName:  "ReadOnly",
+		Duration:     3600,
+		NoCache:      true,
+	}
+	var creds *Credentials
+	var err error
+	captureStderr(t, func() { creds, err = obtainCredentials(opts, io.Discard) })
+	if err != nil {
+		t.Fatalf("obtainCredentials failed: %v", err)
+	}
+	if creds.AccessKeyId != "ASIASSO" {
+		t.Errorf("Expected the IAM Identity Center credentials, got '%s'", creds.AccessKeyId)
+	}
+
+	opts.RoleArns = []string{"arn:aws:iam::123456789012:role/TestRole"}
+	creds, err = obtainCredentials(opts, io.Discard)
+	if err != nil {
+		t.Fatalf("obtainCredentials with a role failed: %v", err)
+	}
+	if creds.AccessKeyId != "ASIAMOCK123456789012" {
+		t.Errorf("Expected the assumed role credentials, got '%s'", creds.AccessKeyId)
+	}
+}
+
+// captureStderr runs fn with stderr redirected and returns what was written
+func captureStderr(t *testing.T, fn func()) string {
+	t.Helper()
+	oldStderr := os.Stderr
+	r, w, _ := os.Pipe()
+	os.Stderr = w
+	fn()
+	w.Close()
+	os.Stderr = oldStderr
+	output, _ := io.ReadAll(r)
+	return string(output)
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	stsAPIVersion = "2011-06-15"
+	iamAPIVersion = "2010-05-08"
+)
+
+// Backend names accepted by --backend
+const (
+	backendNative = "native"
+	backendCLI    = "cli"
+)
+
+// stsBackend selects how AWS is called: natively over HTTPS or through the aws CLI
+var stsBackend = backendNative
+
+// newSTSClient is a variable so tests can replace the backend
+var newSTSClient = newSTSClientFunc
+
+// stsAPI is implemented by every backend that can call AWS STS and IAM
+type stsAPI interface {
+	AssumeRole(input *assumeRoleInput) (*assumeRoleResult, error)
+	AssumeRoleWithWebIdentity(input *webIdentityInput) (*assumeRoleResult, error)
+	AssumeRoleWithSAML(input *samlInput) (*assumeRoleResult, error)
+	GetCallerIdentity() (*callerIdentity, error)
+	GetSessionToken(input *getSessionTokenInput) (*Credentials, error)
+	ListMFADevices() ([]string, error)
+}
+
+// assumeRoleInput holds the parameters of an STS AssumeRole call
+type assumeRoleInput struct {
+	RoleArn         string
+	RoleSessionName string
+	DurationSeconds int
+	ExternalId      string
+	SerialNumber    string
+	TokenCode       string
+	SourceIdentity  string
+	// Tags are session tags; the keys in TransitiveTagKeys carry over to chained sessions
+	Tags              []sessionTag
+	TransitiveTagKeys []string
+	// Policy and PolicyArns are session policies that scope down the role
+	Policy     string
+	PolicyArns []string
+}
+
+// sessionTag is a session tag passed to AssumeRole
+type sessionTag struct {
+	Key   string
+	Value string
+}
+
+// assumeRoleResult is the result of an STS AssumeRole call
+type assumeRoleResult struct {
+	Credentials      Credentials     `xml:"Credentials" json:"Credentials"`
+	AssumedRoleUser  assumedRoleUser `xml:"AssumedRoleUser" json:"AssumedRoleUser"`
+	PackedPolicySize int             `xml:"PackedPolicySize" json:"PackedPolicySize"`
+}
+
+// assumedRoleUser identifies the session created by AssumeRole
+type assumedRoleUser struct {
+	Arn           string `xml:"Arn" json:"Arn"`
+	AssumedRoleId string `xml:"AssumedRoleId" json:"AssumedRoleId"`
+}
+
+// webIdentityInput holds the parameters of an STS AssumeRoleWithWebIdentity call
+type webIdentityInput struct {
+	RoleArn          string
+	RoleSessionName  string
+	WebIdentityToken string
+	DurationSeconds  int
+	Policy           string
+	PolicyArns       []string
+}
+
+// samlInput holds the parameters of an STS AssumeRoleWithSAML call
+type samlInput struct {
+	RoleArn         string
+	PrincipalArn    string
+	SAMLAssertion   string
+	DurationSeconds int
+	Policy          string
+	PolicyArns      []string
+}
+
+// getSessionTokenInput holds the parameters of an STS GetSessionToken call
+type getSessionTokenInput struct {
+	DurationSeconds int
+	SerialNumber    string
+	TokenCode       string
+}
+
+// callerIdentity is the result of an STS GetCallerIdentity call
+type callerIdentity struct {
+	Account string `xml:"Account" json:"Account"`
+	Arn     string `xml:"Arn" json:"Arn"`
+	UserId  string `xml:"UserId" json:"UserId"`
+}
+
+// awsAPIError is an error response returned by an AWS query API
+type awsAPIError struct {
+	StatusCode int
+	Code       string `xml:"Error>Code"`
+	Message    string `xml:"Error>Message"`
+	RequestID  string `xml:"RequestId"`
+}
+
+func (e *awsAPIError) Error() string {
+	return fmt.Sprintf("%s: %s (status %d, request id %s)", e.Code, e.Message, e.StatusCode, e.RequestID)
+}
+
+// newSTSClientFunc returns a client for the selected backend using the credentials of profile
+func newSTSClientFunc(profile string) (stsAPI, error) {
+	switch stsBackend {
+	case backendCLI:
+		return &cliSTSClient{profile: profile}, nil
+	case backendNative, "":
+		credentials, err := resolveSourceCredentials(profile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve source credentials: %w", err)
+		}
+		return newNativeSTSClient(credentials, resolveRegion(profile)), nil
+	default:
+		return nil, fmt.Errorf("unsupported backend: %s", stsBackend)
+	}
+}
+
+// newSessionSTSClient returns a client for the selected backend that signs
+// with temporary credentials held in memory, e.g. from a previous hop
+func newSessionSTSClient(credentials *Credentials, region string) (stsAPI, error) {
+	switch stsBackend {
+	case backendCLI:
+		return &cliSTSClient{credentials: credentials, region: region}, nil
+	case backendNative, "":
+		return newNativeSTSClient(credentials, region), nil
+	default:
+		return nil, fmt.Errorf("unsupported backend: %s", stsBackend)
+	}
+}
+
+// newAnonymousSTSClient returns a client for the selected backend that sends
+// unsigned requests, for calls like AssumeRoleWithWebIdentity and
+// AssumeRoleWithSAML that carry their own proof
+func newAnonymousSTSClient(region string) (stsAPI, error) {
+	switch stsBackend {
+	case backendCLI:
+		return &cliSTSClient{region: region, anonymous: true}, nil
+	case backendNative, "":
+		return newNativeSTSClient(nil, region), nil
+	default:
+		return nil, fmt.Errorf("unsupported backend: %s", stsBackend)
+	}
+}
+
+// nativeSTSClient calls STS and IAM directly with SigV4 signed requests
+type nativeSTSClient struct {
+	credentials *Credentials
+	region      string
+	httpClient  *http.Client
+}
+
+// newNativeSTSClient returns a native client that signs requests with credentials
+func newNativeSTSClient(credentials *Credentials, region string) *nativeSTSClient {
+	return &nativeSTSClient{
+		credentials: credentials,
+		region:      region,
+		httpClient:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// AssumeRole calls sts:AssumeRole
+func (c *nativeSTSClient) AssumeRole(input *assumeRoleInput) (*assumeRoleResult, error) {
+	params := url.Values{}
+	params.Set("RoleArn", input.RoleArn)
+	params.Set("RoleSessionName", input.RoleSessionName)
+	if input.DurationSeconds > 0 {
+		params.Set("DurationSeconds", strconv.Itoa(input.DurationSeconds))
+	}
+	if input.ExternalId != "" {
+		params.Set("ExternalId", input.ExternalId)
+	}
+	if input.SerialNumber != "" && input.TokenCode != "" {
+		params.Set("SerialNumber", input.SerialNumber)
+		params.Set("TokenCode", input.TokenCode)
+	}
+	if input.SourceIdentity != "" {
+		params.Set("SourceIdentity", input.SourceIdentity)
+	}
+	for i, tag := range input.Tags {
+		params.Set(fmt.Sprintf("Tags.member.%d.Key", i+1), tag.Key)
+		params.Set(fmt.Sprintf("Tags.member.%d.Value", i+1), tag.Value)
+	}
+	for i, key := range input.TransitiveTagKeys {
+		params.Set(fmt.Sprintf("TransitiveTagKeys.member.%d", i+1), key)
+	}
+	if input.Policy != "" {
+		params.Set("Policy", input.Policy)
+	}
+	for i, arn := range input.PolicyArns {
+		params.Set(fmt.Sprintf("PolicyArns.member.%d.arn", i+1), arn)
+	}
+
+	var response struct {
+		Result assumeRoleResult `xml:"AssumeRoleResult"`
+	}
+	endpoint, signingRegion := stsEndpoint(c.region)
+	if err := c.call("sts", endpoint, signingRegion, "AssumeRole", stsAPIVersion, params, &response); err != nil {
+		return nil, err
+	}
+	return &response.Result, nil
+}
+
+// AssumeRoleWithWebIdentity calls sts:AssumeRoleWithWebIdentity
+func (c *nativeSTSClient) AssumeRoleWithWebIdentity(input *webIdentityInput) (*assumeRoleResult, error) {
+	params := url.Values{}
+	params.Set("RoleArn", input.RoleArn)
+	params.Set("RoleSessionName", input.RoleSessionName)
+	params.Set("WebIdentityToken", input.WebIdentityToken)
+	if input.DurationSeconds > 0 {
+		params.Set("DurationSeconds", strconv.Itoa(input.DurationSeconds))
+	}
+	if input.Policy != "" {
+		params.Set("Policy", input.Policy)
+	}
+	for i, arn := range input.PolicyArns {
+		params.Set(fmt.Sprintf("PolicyArns.member.%d.arn", i+1), arn)
+	}
+
+	var response struct {
+		Result assumeRoleResult `xml:"AssumeRoleWithWebIdentityResult"`
+	}
+	endpoint, signingRegion := stsEndpoint(c.region)
+	if err := c.call("sts", endpoint, signingRegion, "AssumeRoleWithWebIdentity", stsAPIVersion, params, &response); err != nil {
+		return nil, err
+	}
+	return &response.Result, nil
+}
+
+// AssumeRoleWithSAML calls sts:AssumeRoleWithSAML
+func (c *nativeSTSClient) AssumeRoleWithSAML(input *samlInput) (*assumeRoleResult, error) {
+	params := url.Values{}
+	params.Set("RoleArn", input.RoleArn)
+	params.Set("PrincipalArn", input.PrincipalArn)
+	params.Set("SAMLAssertion", input.SAMLAssertion)
+	if input.DurationSeconds > 0 {
+		params.Set("DurationSeconds", strconv.Itoa(input.DurationSeconds))
+	}
+	if input.Policy != "" {
+		params.Set("Policy", input.Policy)
+	}
+	for i, arn := range input.PolicyArns {
+		params.Set(fmt.Sprintf("PolicyArns.member.%d.arn", i+1), arn)
+	}
+
+	var response struct {
+		Result assumeRoleResult `xml:"AssumeRoleWithSAMLResult"`
+	}
+	endpoint, signingRegion := stsEndpoint(c.region)
+	if err := c.call("sts", endpoint, signingRegion, "AssumeRoleWithSAML", stsAPIVersion, params, &response); err != nil {
+		return nil, err
+	}
+	return &response.Result, nil
+}
+
+// GetCallerIdentity calls sts:GetCallerIdentity
+func (c *nativeSTSClient) GetCallerIdentity() (*callerIdentity, error) {
+	var response struct {
+		Result callerIdentity `xml:"GetCallerIdentityResult"`
+	}
+	endpoint, signingRegion := stsEndpoint(c.region)
+	if err := c.call("sts", endpoint, signingRegion, "GetCallerIdentity", stsAPIVersion, url.Values{}, &response); err != nil {
+		return nil, err
+	}
+	return &response.Result, nil
+}
+
+// GetSessionToken calls sts:GetSessionToken
+func (c *nativeSTSClient) GetSessionToken(input *getSessionTokenInput) (*Credentials, error) {
+	params := url.Values{}
+	if input.DurationSeconds > 0 {
+		params.Set("DurationSeconds", strconv.Itoa(input.DurationSeconds))
+	}
+	if input.SerialNumber != "" && input.TokenCode != "" {
+		params.Set("SerialNumber", input.SerialNumber)
+		params.Set("TokenCode", input.TokenCode)
+	}
+
+	var response struct {
+		Credentials Credentials `xml:"GetSessionTokenResult>Credentials"`
+	}
+	endpoint, signingRegion := stsEndpoint(c.region)
+	if err := c.call("sts", endpoint, signingRegion, "GetSessionToken", stsAPIVersion, params, &response); err != nil {
+		return nil, err
+	}
+	return &response.Credentials, nil
+}
+
+// ListMFADevices calls iam:ListMFADevices and returns the serial numbers
+func (c *nativeSTSClient) ListMFADevices() ([]string, error) {
+	var serials []string
+	params := url.Values{}
+	for {
+		var response struct {
+			Devices     []string `xml:"ListMFADevicesResult>MFADevices>member>SerialNumber"`
+			IsTruncated bool     `xml:"ListMFADevicesResult>IsTruncated"`
+			Marker      string   `xml:"ListMFADevicesResult>Marker"`
+		}
+		endpoint, signingRegion := iamEndpoint(c.region)
+		if err := c.call("iam", endpoint, signingRegion, "ListMFADevices", iamAPIVersion, params, &response); err != nil {
+			return nil, err
+		}
+		serials = append(serials, response.Devices...)
+
+		// Follow pagination until every device has been listed
+		if !response.IsTruncated || response.Marker == "" {
+			return serials, nil
+		}
+		params.Set("Marker", response.Marker)
+	}
+}
+
+// call sends a signed query API request and decodes the XML response into out
+func (c *nativeSTSClient) call(service, endpoint, signingRegion, action, version string, params url.Values, out interface{}) error {
+	params.Set("Action", action)
+	params.Set("Version", version)
+	body := []byte(params.Encode())
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(string(body)))
+	if err != nil {
+		return fmt.Errorf("failed to build %s request: %w", action, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	if c.credentials != nil {
+		signRequest(req, body, c.credentials, signingRegion, service, time.Now())
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s request failed: %w", action, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read %s response: %w", action, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &awsAPIError{StatusCode: resp.StatusCode}
+		if xml.Unmarshal(data, apiErr) != nil || apiErr.Code == "" {
+			return fmt.Errorf("%s failed with status %d: %s", action, resp.StatusCode, strings.TrimSpace(string(data)))
+		}
+		return apiErr
+	}
+
+	if err := xml.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to parse %s response: %w", action, err)
+	}
+	return nil
+}
+
+// stsEndpoint returns the STS endpoint URL and signing region for region
+func stsEndpoint(region string) (string, string) {
+	if endpoint := endpointOverride("STS"); endpoint != "" {
+		if region == "" {
+			region = "us-east-1"
+		}
+		return endpoint, region
+	}
+	if region == "" {
+		return "https://sts.amazonaws.com/", "us-east-1"
+	}
+	return fmt.Sprintf("https://sts.%s.%s/", region, dnsSuffix(region)), region
+}
+
+// iamEndpoint returns the IAM endpoint URL and signing region for the partition of region
+func iamEndpoint(region string) (string, string) {
+	if endpoint := endpointOverride("IAM"); endpoint != "" {
+		return endpoint, "us-east-1"
+	}
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		return "https://iam.cn-north-1.amazonaws.com.cn/", "cn-north-1"
+	case strings.HasPrefix(region, "us-gov-"):
+		return "https://iam.us-gov.amazonaws.com/", "us-gov-west-1"
+	default:
+		return "https://iam.amazonaws.com/", "us-east-1"
+	}
+}
+
+// endpointOverride returns the AWS_ENDPOINT_URL_<SERVICE> or AWS_ENDPOINT_URL override
+func endpointOverride(service string) string {
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL_" + service); endpoint != "" {
+		return endpoint
+	}
+	return os.Getenv("AWS_ENDPOINT_URL")
+}
+
+// dnsSuffix returns the DNS suffix of the partition containing region
+func dnsSuffix(region string) string {
+	if strings.HasPrefix(region, "cn-") {
+		return "amazonaws.com.cn"
+	}
+	return "amazonaws.com"
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newMockSTSServer starts an HTTP server that answers STS and IAM query API actions
+func newMockSTSServer(t *testing.T, responses map[string]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDTEST/") {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<ErrorResponse><Error><Code>SignatureDoesNotMatch</Code><Message>unsigned request</Message></Error><RequestId>1</RequestId></ErrorResponse>`)
+			return
+		}
+
+		response, ok := responses[r.PostForm.Get("Action")]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `<ErrorResponse><Error><Code>InvalidAction</Code><Message>%s</Message></Error><RequestId>2</RequestId></ErrorResponse>`, r.PostForm.Get("Action"))
+			return
+		}
+		fmt.Fprint(w, response)
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+	return server
+}
+
+const mockAssumeRoleResponse = `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <AssumedRoleUser>
+      <Arn>arn:aws:sts::123456789012:assumed-role/TestRole/TempSession</Arn>
+      <AssumedRoleId>AROAMOCK123456789012:TempSession</AssumedRoleId>
+    </AssumedRoleUser>
+    <Credentials>
+      <AccessKeyId>ASIAMOCK123456789012</AccessKeyId>
+      <SecretAccessKey>mockSecretKey123456789012345678901234</SecretAccessKey>
+      <SessionToken>mockSessionToken</SessionToken>
+      <Expiration>2023-12-31T23:59:59Z</Expiration>
+    </Credentials>
+    <PackedPolicySize>6</PackedPolicySize>
+  </AssumeRoleResult>
+  <ResponseMetadata><RequestId>3</RequestId></ResponseMetadata>
+</AssumeRoleResponse>`
+
+// Test the native client against a local STS stand-in
+func TestNativeSTSClient(t *testing.T) {
+	newMockSTSServer(t, map[string]string{
+		"AssumeRole": mockAssumeRoleResponse,
+		"GetCallerIdentity": `<GetCallerIdentityResponse><GetCallerIdentityResult>
+			<Arn>arn:aws:iam::123456789012:user/alice</Arn><UserId>AIDAMOCK</UserId><Account>123456789012</Account>
+			</GetCallerIdentityResult></GetCallerIdentityResponse>`,
+		"GetSessionToken": `<GetSessionTokenResponse><GetSessionTokenResult><Credentials>
+			<AccessKeyId>ASIASESSION</AccessKeyId><SecretAccessKey>secret</SecretAccessKey>
+			<SessionToken>token</SessionToken><Expiration>2023-12-31T23:59:59Z</Expiration>
+			</Credentials></GetSessionTokenResult></GetSessionTokenResponse>`,
+		"ListMFADevices": `<ListMFADevicesResponse><ListMFADevicesResult><MFADevices>
+			<member><SerialNumber>arn:aws:iam::123456789012:mfa/alice</SerialNumber></member>
+			<member><SerialNumber>arn:aws:iam::123456789012:mfa/alice-yubikey</SerialNumber></member>
+			</MFADevices><IsTruncated>false</IsTruncated></ListMFADevicesResult></ListMFADevicesResponse>`,
+	})
+
+	client := newNativeSTSClient(&Credentials{AccessKeyId: "AKIDTEST", SecretAccessKey: "secret"}, "us-west-2")
+
+	// Test AssumeRole
+	result, err := client.AssumeRole(&assumeRoleInput{RoleArn: "arn:aws:iam::123456789012:role/TestRole", RoleSessionName: "test", DurationSeconds: 3600})
+	if err != nil {
+		t.Fatalf("AssumeRole failed: %v", err)
+	}
+	if result.Credentials.AccessKeyId != "ASIAMOCK123456789012" {
+		t.Errorf("Expected AccessKeyId 'ASIAMOCK123456789012', got '%s'", result.Credentials.AccessKeyId)
+	}
+	if result.Credentials.Expiration.Year() != 2023 {
+		t.Errorf("Expected Expiration in 2023, got %v", result.Credentials.Expiration)
+	}
+	if result.AssumedRoleUser.Arn != "arn:aws:sts::123456789012:assumed-role/TestRole/TempSession" {
+		t.Errorf("Unexpected AssumedRoleUser ARN '%s'", result.AssumedRoleUser.Arn)
+	}
+	if result.PackedPolicySize != 6 {
+		t.Errorf("Expected PackedPolicySize 6, got %d", result.PackedPolicySize)
+	}
+
+	// Test GetCallerIdentity
+	identity, err := client.GetCallerIdentity()
+	if err != nil {
+		t.Fatalf("GetCallerIdentity failed: %v", err)
+	}
+	if identity.Account != "123456789012" || identity.Arn != "arn:aws:iam::123456789012:user/alice" {
+		t.Errorf("Unexpected caller identity %+v", identity)
+	}
+
+	// Test GetSessionToken
+	session, err := client.GetSessionToken(&getSessionTokenInput{DurationSeconds: 3600})
+	if err != nil {
+		t.Fatalf("GetSessionToken failed: %v", err)
+	}
+	if session.AccessKeyId != "ASIASESSION" {
+		t.Errorf("Expected AccessKeyId 'ASIASESSION', got '%s'", session.AccessKeyId)
+	}
+
+	// Test ListMFADevices
+	devices, err := client.ListMFADevices()
+	if err != nil {
+		t.Fatalf("ListMFADevices failed: %v", err)
+	}
+	if len(devices) != 2 || devices[1] != "arn:aws:iam::123456789012:mfa/alice-yubikey" {
+		t.Errorf("Unexpected MFA devices %v", devices)
+	}
+}
+
+// Test that STS error responses are surfaced as awsAPIError
+func TestNativeSTSClientError(t *testing.T) {
+	newMockSTSServer(t, map[string]string{})
+
+	client := newNativeSTSClient(&Credentials{AccessKeyId: "AKIDTEST", SecretAccessKey: "secret"}, "")
+	_, err := client.AssumeRole(&assumeRoleInput{RoleArn: "arn:aws:iam::123456789012:role/TestRole", RoleSessionName: "test"})
+
+	var apiErr *awsAPIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected awsAPIError, got %v", err)
+	}
+	if apiErr.Code != "InvalidAction" || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("Unexpected error %+v", apiErr)
+	}
+}
+
+// Test assumeRole with the native backend
+func TestAssumeRoleNative(t *testing.T) {
+	newMockSTSServer(t, map[string]string{"AssumeRole": mockAssumeRoleResponse})
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	client, err := newSTSClient("")
+	if err != nil {
+		t.Fatalf("newSTSClient failed: %v", err)
+	}
+	result, err := assumeRole(client, &assumeRoleInput{RoleArn: "arn:aws:iam::123456789012:role/TestRole", DurationSeconds: 3600})
+	if err != nil {
+		t.Fatalf("assumeRole failed: %v", err)
+	}
+	if result.Credentials.AccessKeyId != "ASIAMOCK123456789012" {
+		t.Errorf("Expected AccessKeyId 'ASIAMOCK123456789012', got '%s'", result.Credentials.AccessKeyId)
+	}
+	if result.AssumedRoleUser.AssumedRoleId != "AROAMOCK123456789012:TempSession" {
+		t.Errorf("Unexpected AssumedRoleId '%s'", result.AssumedRoleUser.AssumedRoleId)
+	}
+}
+
+// Test endpoint selection for regions and partitions
+func TestSTSEndpoint(t *testing.T) {
+	testCases := []struct {
+		region          string
+		expectedURL     string
+		expectedSigning string
+	}{
+		{"", "https://sts.amazonaws.com/", "us-east-1"},
+		{"eu-west-1", "https://sts.eu-west-1.amazonaws.com/", "eu-west-1"},
+		{"cn-north-1", "https://sts.cn-north-1.amazonaws.com.cn/", "cn-north-1"},
+	}
+	for _, tc := range testCases {
+		endpoint, signingRegion := stsEndpoint(tc.region)
+		if endpoint != tc.expectedURL || signingRegion != tc.expectedSigning {
+			t.Errorf("stsEndpoint(%q) = %s, %s; expected %s, %s", tc.region, endpoint, signingRegion, tc.expectedURL, tc.expectedSigning)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// targetConfig is the awsomecreds config file, which names sets of role options
+type targetConfig struct {
+	Targets map[string]target `yaml:"targets"`
+}
+
+// target is a named role to assume, so it can be used without repeating its flags
+type target struct {
+	RoleArn       string            `yaml:"role_arn"`
+	RoleArns      []string          `yaml:"role_arns"`
+	SourceProfile string            `yaml:"source_profile"`
+	Region        string            `yaml:"region"`
+	Duration      int               `yaml:"duration"`
+	MFASerial     string            `yaml:"mfa_serial"`
+	ExternalID    string            `yaml:"external_id"`
+	Tags          map[string]string `yaml:"tags"`
+}
+
+// targetConfigFile returns the path of the config file, which
+// AWSOMECREDS_CONFIG overrides
+func targetConfigFile() string {
+	if path := os.Getenv("AWSOMECREDS_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+	return filepath.Join(dir, "awsomecreds", "config.yaml")
+}
+
+// loadTargetConfig reads and validates the config file at path
+func loadTargetConfig(path string) (*targetConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var config targetConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	// Misspelled settings would otherwise be ignored silently
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	for _, name := range config.names() {
+		t := config.Targets[name]
+		if err := t.validate(); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: target %q: %w", path, name, err)
+		}
+	}
+	return &config, nil
+}
+
+// names returns the target names in order
+func (c *targetConfig) names() []string {
+	names := make([]string, 0, len(c.Targets))
+	for name := range c.Targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// roleArns returns the roles of the target in order
+func (t *target) roleArns() []string {
+	if t.RoleArn != "" {
+		return []string{t.RoleArn}
+	}
+	return t.RoleArns
+}
+
+// validate checks the settings of the target
+func (t *target) validate() error {
+	if t.RoleArn != "" && len(t.RoleArns) > 0 {
+		return fmt.Errorf("role_arn and role_arns are mutually exclusive")
+	}
+	if len(t.roleArns()) == 0 {
+		return fmt.Errorf("role_arn or role_arns is required")
+	}
+	for _, spec := range t.roleArns() {
+		if _, err := parseRoleHop(spec); err != nil {
+			return err
+		}
+	}
+	if t.Duration != 0 && (t.Duration < 900 || t.Duration > 43200) {
+		return fmt.Errorf("duration must be between 900 and 43200 seconds, got %d", t.Duration)
+	}
+	for key := range t.Tags {
+		if key == "" || strings.Contains(key, "=") {
+			return fmt.Errorf("invalid tag key %q", key)
+		}
+	}
+	return nil
+}
+
+// applyTarget fills opts with the settings of the named target. Settings
+// whose flag was given on the command line, as reported by changed, are kept.
+func applyTarget(opts *roleOptions, name string, changed func(flag string) bool) error {
+	path := targetConfigFile()
+	config, err := loadTargetConfig(path)
+	if err != nil {
+		return err
+	}
+	t, ok := config.Targets[name]
+	if !ok {
+		return fmt.Errorf("unknown target %q, %s defines: %s", name, path, strings.Join(config.names(), ", "))
+	}
+
+	if !changed("role-arn") {
+		opts.RoleArns = t.roleArns()
+	}
+	if !changed("source-profile") && t.SourceProfile != "" {
+		opts.SourceProfile = t.SourceProfile
+	}
+	if !changed("region") && t.Region != "" {
+		opts.Region = t.Region
+	}
+	if !changed("duration") && t.Duration != 0 {
+		opts.Duration = t.Duration
+	}
+	if !changed("mfa-serial") && !changed("mfa-device") && t.MFASerial != "" {
+		opts.MFASerial = t.MFASerial
+		// A target names an MFA device because its role requires MFA, so ask
+		// for a code (or use a cached MFA session) unless a hop says #mfa
+		opts.MFAPrompt = true
+	}
+	if !changed("external-id") && t.ExternalID != "" {
+		opts.ExternalID = t.ExternalID
+	}
+	if !changed("tag") && len(t.Tags) > 0 {
+		opts.Tags = nil
+		for key, value := range t.Tags {
+			opts.Tags = append(opts.Tags, key+"="+value)
+		}
+		sort.Strings(opts.Tags)
+	}
+	return nil
+}
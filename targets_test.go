@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testTargetConfig = `
+targets:
+  prod-admin:
+    role_arn: arn:aws:iam::123456789012:role/admin
+    source_profile: corp
+    region: us-east-1
+    duration: 7200
+    mfa_serial: arn:aws:iam::111111111111:mfa/alice
+    external_id: xyz
+    tags:
+      team: platform
+      cost-center: "42"
+  vendor:
+    role_arns:
+      - arn:aws:iam::111111111111:role/hub#mfa
+      - arn:aws:iam::222222222222:role/vendor#external-id=abc
+`
+
+// Test that targets fill in the role options
+func TestApplyTarget(t *testing.T) {
+	t.Setenv("AWSOMECREDS_CONFIG", writeTestFile(t, "config.yaml", testTargetConfig))
+
+	opts := &roleOptions{Duration: 3600}
+	if err := applyTarget(opts, "prod-admin", func(string) bool { return false }); err != nil {
+		t.Fatalf("applyTarget failed: %v", err)
+	}
+	if strings.Join(opts.RoleArns, ",") != "arn:aws:iam::123456789012:role/admin" || opts.SourceProfile != "corp" ||
+		opts.Region != "us-east-1" || opts.Duration != 7200 || opts.MFASerial != "arn:aws:iam::111111111111:mfa/alice" ||
+		opts.ExternalID != "xyz" || strings.Join(opts.Tags, ",") != "cost-center=42,team=platform" {
+		t.Errorf("Unexpected options %+v", opts)
+	}
+
+	opts = &roleOptions{}
+	if err := applyTarget(opts, "vendor", func(string) bool { return false }); err != nil {
+		t.Fatalf("applyTarget failed: %v", err)
+	}
+	if len(opts.RoleArns) != 2 || opts.RoleArns[1] != "arn:aws:iam::222222222222:role/vendor#external-id=abc" {
+		t.Errorf("Expected the role chain of the target, got %v", opts.RoleArns)
+	}
+}
+
+// Test that flags given on the command line override the target
+func TestApplyTargetFlagsOverride(t *testing.T) {
+	t.Setenv("AWSOMECREDS_CONFIG", writeTestFile(t, "config.yaml", testTargetConfig))
+
+	opts := &roleOptions{Region: "eu-west-1", Duration: 900, Tags: []string{"team=security"}}
+	changed := func(flag string) bool { return flag == "region" || flag == "duration" || flag == "tag" }
+	if err := applyTarget(opts, "prod-admin", changed); err != nil {
+		t.Fatalf("applyTarget failed: %v", err)
+	}
+	if opts.Region != "eu-west-1" || opts.Duration != 900 || strings.Join(opts.Tags, ",") != "team=security" {
+		t.Errorf("Expected the flags to win, got %+v", opts)
+	}
+	if opts.SourceProfile != "corp" {
+		t.Errorf("Expected the source profile of the target, got '%s'", opts.SourceProfile)
+	}
+}
+
+// Test that invalid config files are rejected with the reason
+func TestLoadTargetConfigErrors(t *testing.T) {
+	testCases := []struct {
+		name        string
+		config      string
+		expectedErr string
+	}{
+		{"unknown setting", "targets:\n  a:\n    role_arn: arn:aws:iam::123456789012:role/a\n    regoin: us-east-1\n", "field regoin not found"},
+		{"missing role", "targets:\n  a:\n    region: us-east-1\n", `target "a": role_arn or role_arns is required`},
+		{"both roles", "targets:\n  a:\n    role_arn: arn:aws:iam::123456789012:role/a\n    role_arns: [arn:aws:iam::123456789012:role/b]\n", "mutually exclusive"},
+		{"invalid ARN", "targets:\n  a:\n    role_arn: my-role\n", `target "a"`},
+		{"duration", "targets:\n  a:\n    role_arn: arn:aws:iam::123456789012:role/a\n    duration: 60\n", "duration must be between 900 and 43200 seconds"},
+		{"duration type", "targets:\n  a:\n    role_arn: arn:aws:iam::123456789012:role/a\n    duration: 1h\n", "cannot unmarshal"},
+		{"syntax", "targets:\n  a: [\n", "invalid config file"},
+	}
+
+	for _, tc := range testCases {
+		_, err := loadTargetConfig(writeTestFile(t, "config.yaml", tc.config))
+		if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+			t.Errorf("%s: expected error containing %q, got %v", tc.name, tc.expectedErr, err)
+		}
+	}
+}
+
+// Test that unknown targets list the defined ones
+func TestApplyTargetUnknown(t *testing.T) {
+	t.Setenv("AWSOMECREDS_CONFIG", writeTestFile(t, "config.yaml", testTargetConfig))
+
+	err := applyTarget(&roleOptions{}, "staging", func(string) bool { return false })
+	if err == nil || !strings.Contains(err.Error(), "prod-admin, vendor") {
+		t.Errorf("Expected an error listing the targets, got %v", err)
+	}
+}
+
+// Test that a target with an MFA device assumes its role with MFA
+func TestApplyTargetMFA(t *testing.T) {
+	t.Setenv("AWSOMECREDS_CONFIG", writeTestFile(t, "config.yaml", `
+targets:
+  prod:
+    role_arn: arn:aws:iam::123456789012:role/admin
+    mfa_serial: arn:aws:iam::123456789012:mfa/alice
+`))
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	var serialNumber, tokenCode string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		serialNumber, tokenCode = r.PostForm.Get("SerialNumber"), r.PostForm.Get("TokenCode")
+		fmt.Fprint(w, mockAssumeRoleResponse)
+	}))
+	defer server.Close()
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+
+	origReadMFACode := readMFACode
+	readMFACode = func(device string) (string, error) { return "123456", nil }
+	defer func() { readMFACode = origReadMFACode }()
+
+	opts := &roleOptions{Duration: 3600, NoCache: true}
+	if err := applyTarget(opts, "prod", func(string) bool { return false }); err != nil {
+		t.Fatalf("applyTarget failed: %v", err)
+	}
+	if _, err := obtainCredentials(opts, io.Discard); err != nil {
+		t.Fatalf("obtainCredentials failed: %v", err)
+	}
+	if serialNumber != "arn:aws:iam::123456789012:mfa/alice" || tokenCode != "123456" {
+		t.Errorf("Expected the role to be assumed with MFA, got SerialNumber '%s' and TokenCode '%s'", serialNumber, tokenCode)
+	}
+}
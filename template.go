@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// templateData is the data model of the template format
+type templateData struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	// Expiration is a time.Time in UTC, so templates can call .Expiration.Format
+	Expiration        time.Time
+	ExpirationRFC3339 string
+	ExpirationUnix    int64
+	ExpirationLocal   string
+	ExpiresInSeconds  int64
+	Region            string
+	// RoleArn is the last role of the chain, without per-role options
+	RoleArn string
+	// AssumedRoleUser is the ARN of the session, looked up only when the template uses it
+	AssumedRoleUser string
+	AccountId       string
+}
+
+// templateFuncs are the helper functions available to templates
+var templateFuncs = template.FuncMap{
+	"shellquote": posixQuote,
+	"base64": func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	},
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// parseOutputTemplate parses the template of out, from --template or --template-file
+func parseOutputTemplate(out *outputOptions) (*template.Template, error) {
+	text := out.Template
+	if out.TemplateFile != "" {
+		data, err := os.ReadFile(out.TemplateFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template file: %w", err)
+		}
+		text = string(data)
+	}
+	if text == "" {
+		return nil, fmt.Errorf("the template format requires --template or --template-file")
+	}
+
+	tmpl, err := template.New("output").Option("missingkey=error").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// newTemplateData builds the data model for credentials assumed with opts
+func newTemplateData(opts *roleOptions, credentials *Credentials, region string, now time.Time) *templateData {
+	data := &templateData{
+		AccessKeyId:       credentials.AccessKeyId,
+		SecretAccessKey:   credentials.SecretAccessKey,
+		SessionToken:      credentials.SessionToken,
+		Expiration:        credentials.Expiration.UTC(),
+		ExpirationRFC3339: credentials.Expiration.UTC().Format(time.RFC3339),
+		ExpirationUnix:    credentials.Expiration.Unix(),
+		ExpirationLocal:   credentials.Expiration.Local().Format("2006-01-02 15:04:05 MST"),
+		Region:            region,
+		AccountId:         opts.SSOAccountID,
+	}
+	if remaining := credentials.Expiration.Sub(now); remaining > 0 {
+		data.ExpiresInSeconds = int64(remaining.Seconds())
+	}
+	if len(opts.RoleArns) > 0 {
+		data.RoleArn, _, _ = strings.Cut(opts.RoleArns[len(opts.RoleArns)-1], "#")
+		// arn:partition:iam::account:role/name
+		if fields := strings.Split(data.RoleArn, ":"); len(fields) >= 5 {
+			data.AccountId = fields[4]
+		}
+	}
+	return data
+}
+
+// templateNeedsIdentity reports whether the template refers to the session's identity
+func templateNeedsIdentity(tmpl *template.Template) bool {
+	return strings.Contains(tmpl.Root.String(), "AssumedRoleUser")
+}
+
+// writeTemplate renders tmpl with the credentials to w. Nothing is written if rendering fails.
+func writeTemplate(w io.Writer, tmpl *template.Template, opts *roleOptions, credentials *Credentials, region string) error {
+	data := newTemplateData(opts, credentials, region, time.Now())
+	if templateNeedsIdentity(tmpl) {
+		client, err := newSessionSTSClient(credentials, region)
+		if err != nil {
+			return err
+		}
+		identity, err := client.GetCallerIdentity()
+		if err != nil {
+			return fmt.Errorf("error getting the identity of the session: %w", err)
+		}
+		data.AssumedRoleUser = identity.Arn
+		data.AccountId = identity.Account
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return fmt.Errorf("error rendering template: %w", err)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test where the template comes from and how bad templates are reported
+func TestParseOutputTemplate(t *testing.T) {
+	file := writeTestFile(t, "creds.tmpl", "key={{.AccessKeyId}}")
+
+	testCases := []struct {
+		name        string
+		out         outputOptions
+		expectedErr string
+	}{
+		{name: "Inline", out: outputOptions{Template: "{{.AccessKeyId}}"}},
+		{name: "File", out: outputOptions{TemplateFile: file}},
+		{name: "Missing", out: outputOptions{}, expectedErr: "requires --template or --template-file"},
+		{name: "Missing file", out: outputOptions{TemplateFile: filepath.Join(t.TempDir(), "nope")}, expectedErr: "failed to read template file"},
+		{name: "Syntax error", out: outputOptions{Template: "{{.AccessKeyId"}, expectedErr: "invalid template"},
+		{name: "Unknown function", out: outputOptions{Template: "{{nope .AccessKeyId}}"}, expectedErr: "invalid template"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseOutputTemplate(&tc.out)
+			if tc.expectedErr == "" && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if tc.expectedErr != "" && (err == nil || !strings.Contains(err.Error(), tc.expectedErr)) {
+				t.Errorf("Expected error containing %q, got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+// Test the data model and helper functions of the template format
+func TestWriteTemplate(t *testing.T) {
+	credentials := &Credentials{
+		AccessKeyId:     "ASIAEXAMPLE",
+		SecretAccessKey: "it's secret",
+		SessionToken:    "token",
+		Expiration:      time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	opts := &roleOptions{RoleArns: []string{
+		"arn:aws:iam::111111111111:role/first",
+		"arn:aws:iam::222222222222:role/second#external_id=abc",
+	}}
+
+	testCases := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{name: "Fields", template: "{{.AccessKeyId}} {{.Region}} {{.RoleArn}} {{.AccountId}}", expected: "ASIAEXAMPLE eu-west-1 arn:aws:iam::222222222222:role/second 222222222222"},
+		{name: "Expiration", template: "{{.ExpirationRFC3339}} {{.ExpirationUnix}} {{.Expiration.Format \"2006-01-02\"}}", expected: "2030-01-02T03:04:05Z 1893553445 2030-01-02"},
+		{name: "Shell quoting", template: "export S={{shellquote .SecretAccessKey}}", expected: `export S='it'\''s secret'`},
+		{name: "Base64", template: "{{base64 .SessionToken}}", expected: "dG9rZW4="},
+		{name: "JSON", template: "{{json .SecretAccessKey}}", expected: `"it's secret"`},
+		{name: "Case", template: "{{upper .SessionToken}} {{lower .AccessKeyId}}", expected: "TOKEN asiaexample"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpl, err := parseOutputTemplate(&outputOptions{Template: tc.template})
+			if err != nil {
+				t.Fatalf("Failed to parse template: %v", err)
+			}
+			var buf bytes.Buffer
+			if err := writeTemplate(&buf, tmpl, opts, credentials, "eu-west-1"); err != nil {
+				t.Fatalf("writeTemplate failed: %v", err)
+			}
+			if buf.String() != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, buf.String())
+			}
+		})
+	}
+
+	// Nothing is written when rendering fails half way
+	tmpl, _ := parseOutputTemplate(&outputOptions{Template: "{{.AccessKeyId}}{{.Nope}}"})
+	var buf bytes.Buffer
+	if err := writeTemplate(&buf, tmpl, opts, credentials, "eu-west-1"); err == nil {
+		t.Error("Expected an error for an unknown field")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Expected no output, got %q", buf.String())
+	}
+}
+
+// Test that the session identity is only looked up when the template uses it
+func TestWriteTemplateAssumedRoleUser(t *testing.T) {
+	identity := `<GetCallerIdentityResponse><GetCallerIdentityResult>
+		<Arn>arn:aws:sts::333333333333:assumed-role/admin/alice</Arn><UserId>AROAMOCK:alice</UserId><Account>333333333333</Account>
+		</GetCallerIdentityResult></GetCallerIdentityResponse>`
+	newMockSTSServer(t, map[string]string{"GetCallerIdentity": identity})
+
+	credentials := &Credentials{AccessKeyId: "AKIDTEST", SecretAccessKey: "secret", SessionToken: "token"}
+	tmpl, err := parseOutputTemplate(&outputOptions{Template: "{{.AssumedRoleUser}} {{.AccountId}}"})
+	if err != nil {
+		t.Fatalf("Failed to parse template: %v", err)
+	}
+	if !templateNeedsIdentity(tmpl) {
+		t.Error("Expected the template to need the identity")
+	}
+	var buf bytes.Buffer
+	if err := writeTemplate(&buf, tmpl, &roleOptions{}, credentials, "us-east-1"); err != nil {
+		t.Fatalf("writeTemplate failed: %v", err)
+	}
+	if expected := "arn:aws:sts::333333333333:assumed-role/admin/alice 333333333333"; buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+
+	tmpl, _ = parseOutputTemplate(&outputOptions{Template: "{{.AccessKeyId}}"})
+	if templateNeedsIdentity(tmpl) {
+		t.Error("Expected the template not to need the identity")
+	}
+}
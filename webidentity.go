@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// usesWebIdentity reports whether opts assume the first role with an OIDC token
+func usesWebIdentity(opts *roleOptions) bool {
+	return opts.WebIdentityTokenFile != "" || opts.WebIdentityTokenEnv != ""
+}
+
+// webIdentitySource describes where the web identity token comes from
+func webIdentitySource(opts *roleOptions) string {
+	if opts.WebIdentityTokenEnv != "" {
+		return "$" + opts.WebIdentityTokenEnv
+	}
+	return opts.WebIdentityTokenFile
+}
+
+// readWebIdentityToken reads the OIDC token from --web-identity-token-file or
+// the variable named by --web-identity-token-env. It is read on every call
+// because platforms like Kubernetes rotate the token file.
+func readWebIdentityToken(opts *roleOptions) (string, error) {
+	var token string
+	if opts.WebIdentityTokenEnv != "" {
+		token = os.Getenv(opts.WebIdentityTokenEnv)
+	} else {
+		data, err := os.ReadFile(opts.WebIdentityTokenFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read web identity token: %w", err)
+		}
+		token = string(data)
+	}
+
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", fmt.Errorf("web identity token from %s is empty", webIdentitySource(opts))
+	}
+	return token, nil
+}
+
+// assumeRoleWithWebIdentity assumes the role in input with the web identity
+// token of opts. Session tags and the source identity come from the token,
+// so only the settings AssumeRoleWithWebIdentity accepts are passed on.
+func assumeRoleWithWebIdentity(client stsAPI, opts *roleOptions, input *assumeRoleInput) (*assumeRoleResult, error) {
+	token, err := readWebIdentityToken(opts)
+	if err != nil {
+		return nil, err
+	}
+	if input.RoleSessionName == "" {
+		input.RoleSessionName = defaultSessionName()
+	}
+
+	result, err := client.AssumeRoleWithWebIdentity(&webIdentityInput{
+		RoleArn:          input.RoleArn,
+		RoleSessionName:  input.RoleSessionName,
+		WebIdentityToken: token,
+		DurationSeconds:  input.DurationSeconds,
+		Policy:           input.Policy,
+		PolicyArns:       input.PolicyArns,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Credentials.AccessKeyId == "" || result.Credentials.SecretAccessKey == "" || result.Credentials.SessionToken == "" {
+		return nil, fmt.Errorf("failed to get valid credentials from AWS response")
+	}
+	return result, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test reading web identity tokens from files and environment variables
+func TestReadWebIdentityToken(t *testing.T) {
+	t.Setenv("TEST_OIDC_TOKEN", "eyJenv")
+	t.Setenv("TEST_EMPTY_TOKEN", "")
+
+	testCases := []struct {
+		name        string
+		opts        roleOptions
+		expected    string
+		expectedErr bool
+	}{
+		{name: "file", opts: roleOptions{WebIdentityTokenFile: writeTestFile(t, "token", "eyJfile\n")}, expected: "eyJfile"},
+		{name: "env", opts: roleOptions{WebIdentityTokenEnv: "TEST_OIDC_TOKEN"}, expected: "eyJenv"},
+		{name: "missing file", opts: roleOptions{WebIdentityTokenFile: "/nonexistent/token"}, expectedErr: true},
+		{name: "empty env", opts: roleOptions{WebIdentityTokenEnv: "TEST_EMPTY_TOKEN"}, expectedErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			token, err := readWebIdentityToken(&tc.opts)
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("readWebIdentityToken error = %v, expectedErr %v", err, tc.expectedErr)
+			}
+			if token != tc.expected {
+				t.Errorf("readWebIdentityToken = %q, expected %q", token, tc.expected)
+			}
+		})
+	}
+}
+
+// Test that the first role is assumed with an unsigned web identity call
+// and later roles are signed with its credentials
+func TestObtainCredentialsWebIdentity(t *testing.T) {
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		auth := r.Header.Get("Authorization")
+		signer := strings.SplitN(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 Credential="), "/", 2)[0]
+		action := r.PostForm.Get("Action")
+		calls = append(calls, fmt.Sprintf("%s %s %q %s", action, r.PostForm.Get("RoleArn"), signer, r.PostForm.Get("WebIdentityToken")))
+
+		fmt.Fprintf(w, `<%sResponse><%sResult><Credentials>
+			<AccessKeyId>ASIA%s</AccessKeyId><SecretAccessKey>secret</SecretAccessKey>
+			<SessionToken>token</SessionToken><Expiration>2030-01-01T00:00:00Z</Expiration>
+			</Credentials></%sResult></%sResponse>`, action, action, strings.ToUpper(action), action, action)
+	}))
+	defer server.Close()
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+	t.Setenv("TEST_OIDC_TOKEN", "eyJtoken")
+
+	opts := &roleOptions{
+		WebIdentityTokenEnv: "TEST_OIDC_TOKEN",
+		RoleArns:            []string{"arn:aws:iam::111111111111:role/ci", "arn:aws:iam::222222222222:role/deploy"},
+		Duration:            3600,
+		NoCache:             true,
+	}
+	creds, err := obtainCredentials(opts, io.Discard)
+	if err != nil {
+		t.Fatalf("obtainCredentials failed: %v", err)
+	}
+
+	expected := []string{
+		`AssumeRoleWithWebIdentity arn:aws:iam::111111111111:role/ci "" eyJtoken`,
+		`AssumeRole arn:aws:iam::222222222222:role/deploy "ASIAASSUMEROLEWITHWEBIDENTITY" `,
+	}
+	if strings.Join(calls, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected calls:\n%s\nexpected:\n%s", strings.Join(calls, "\n"), strings.Join(expected, "\n"))
+	}
+	if creds.AccessKeyId != "ASIAASSUMEROLE" {
+		t.Errorf("Expected AccessKeyId 'ASIAASSUMEROLE', got '%s'", creds.AccessKeyId)
+	}
+
+	// MFA cannot be combined with the web identity role
+	opts.RoleArns = []string{"arn:aws:iam::111111111111:role/ci#mfa"}
+	if _, err := obtainCredentials(opts, io.Discard); err == nil || !strings.Contains(err.Error(), "cannot use MFA") {
+		t.Errorf("Expected an MFA error, got %v", err)
+	}
+}